@@ -2,12 +2,15 @@
 package buspirate
 
 import (
-    "github.com/tarm/goserial"
+    "context"
+    "errors"
     "io"
     "log"
+    "math"
+    "strings"
+    "sync"
     "time"
     "fmt"
-    "errors"
     "runtime"
 )
 
@@ -17,47 +20,97 @@ const (
     HW_RESET = 0x0F
     HW_RESET_REPLY = 0x01
     BINARY_RESET = 0x00
+    MODE_BITBANG = 0x00
     STATE_INITIAL = 0x01
     STATE_BINARY = 0x02
-    STATE_RAW = 0x04
 
     MODE_SPI = 0x01
+    MODE_SPI_REPLY = "SPI1"
     MODE_I2C = 0x02
     MODE_I2C_REPLY = "I2C1"
     MODE_UART = 0x03
+    MODE_UART_REPLY = "ART1"
     MODE_1WIRE = 0x04
+    MODE_1WIRE_REPLY = "1W01"
     MODE_RAW = 0x05
+    MODE_RAW_REPLY = "RAW1"
+    MODE_COUNT = 6
     GET_MODE = 0x01
 
     MODE_BB_REPLY = "BBIO1"
     MODE_HIZ_REPLY1 = "\r\nHiZ>"
     MODE_HIZ_REPLY2 = "\n\nHiZ>"
+    MODE_HIZ_PROMPT = "HiZ>"
+    BINARY_MODE_ZEROS = 20
 
     HW_TEST_SHORT = 0x10
     HW_TEST_LONG = 0x11
+    // Ends either self-test, the reply is 0x01.
+    SELF_TEST_EXIT = 0xFF
 
+    // SET_PWM is followed by the prescaler, 0-3 for 1:1, 1:8, 1:64, 1:256,
+    // then the duty cycle and the period registers, 2 bytes each MSB first.
     SET_PWM = 0x12
     CLEAR_PWM = 0x13
+    // Timer 2 runs from the PIC's instruction clock.
+    PWM_FCY = 16000000
 
     VOLT_MEASURE = 0x14
     SET_PINS_IN_OUT = 0x40
+    // 0100wxyz, peripherals in every binary sub mode, w=power, x=pullups,
+    // y=AUX, z=CS
+    SET_PERIPH = 0x40
     SET_PINS_HIGH_LOW = 0x80
 
     DEFAULT_TIMEOUT = 100
+    HW_RESET_TIMEOUT = 500
+    CLOSE_TIMEOUT = 1000
+    SELF_TEST_TIMEOUT = 2000
+    // How long the line has to be idle before a reply of unknown length
+    // is taken as finished.
+    QUIET_TIMEOUT = 20
+    // Time for one byte at 115200 baud, 8N1, rounded up, in µs.
+    SERIAL_BYTE_TIMEOUT = 100
     // DEFAULT_TIMEOUT = 100
 )
 
-type BP struct {
-	Device string
-    Serial io.ReadWriteCloser
-    SerialConf *serial.Config
+// bpConn is what every handle on a BP shares: the connection and what we know
+// about the state of the Bus Pirate. mu is held for every command.
+type bpConn struct {
+    Device string
+    Transport Transport
     ReadTimeout time.Duration
+    mu sync.Mutex
     read_buf []uint8
     read_byte chan uint8
     read_err chan error
+    // Closed by Close, and by the reader once it has stopped.
+    done chan struct{}
+    reader_done chan struct{}
     pins_high_low uint8
     pins_in_out uint8
     state uint8
+    // The binary mode last entered, one of the MODE_* commands.
+    mode uint8
+    // Settings of each binary mode, indexed by its MODE_* command. They
+    // live here, not on the mode types, so every handle sees the same ones.
+    periph [MODE_COUNT]uint8
+    speed [MODE_COUNT]uint8
+    config [MODE_COUNT]uint8
+    uart_baud int
+    uart_echo bool
+}
+
+// BP is a handle on a Bus Pirate. It is safe for concurrent use: every
+// command holds the BP for its whole exchange. For a sequence of commands use
+// Lock or Transaction, which hand out a handle that already holds it.
+type BP struct {
+    *bpConn
+    // This handle holds mu
+    held bool
+    release func()
+    // Locks taken on this handle while it already held mu
+    nested int
 }
 
 func NewBP(dev string) *BP {
@@ -66,49 +119,77 @@ func NewBP(dev string) *BP {
        dev = "/dev/buspirate"
     }
 
-    bp := BP{Device: dev, ReadTimeout: DEFAULT_TIMEOUT * time.Millisecond,
-            pins_high_low: SET_PINS_HIGH_LOW,
-            pins_in_out: SET_PINS_IN_OUT,
+    bp := BP{bpConn: &bpConn{Device: dev, ReadTimeout: DEFAULT_TIMEOUT * time.Millisecond,
+            pins_in_out: uint8(PINS_IO),
             read_byte: make(chan uint8, READ_BUF_SIZE),
             read_err: make(chan error, 1),
-        }
+            done: make(chan struct{}),
+        }}
 
 
     return &bp
 } //NewBP()
 
+// NewBPWithTransport returns a BP that talks over t instead of opening a
+// serial device in Init.
+func NewBPWithTransport(t Transport) *BP {
+
+    bp := NewBP("")
+    bp.Device = ""
+    bp.Transport = t
+
+    return bp
+} //NewBPWithTransport()
+
 func (bp *BP) Init() error {
 
-    bp.SerialConf = &serial.Config{Name: bp.Device, Baud: BAUD}
-    s, err := serial.OpenPort(bp.SerialConf)
+    bp, unlock := bp.acquire()
+    defer unlock()
 
-    if err != nil {
-        return err
+    if bp.isClosed() {
+        return ErrClosed
     }
 
-    bp.Serial = s
+    // Without a Transport, fall back to the serial device.
+    if bp.Transport == nil {
+        t, err := OpenSerial(bp.Device, BAUD)
+        if err != nil {
+            return err
+        }
+        bp.Transport = t
+    }
 
     bp.read_buf = make([]uint8, READ_BUF_SIZE)
+    bp.reader_done = make(chan struct{})
 
     // Start the reader!.
     go func() {
         buf := bp.read_buf
-        fd := bp.Serial
+        fd := bp.Transport
         read_byte := bp.read_byte
         read_err := bp.read_err
+        done := bp.done
+        defer close(bp.reader_done)
 
         // log.Printf("Starting reader...")
         for {
             // log.Printf("Reader TOP of loop")
             n, err := fd.Read(buf)
             if err != nil || n == 0 {
-                read_err <- err
-                break
+                select {
+                    case read_err <- err:
+                    case <-done:
+                }
+                return
             }
             // log.Printf("Reader %d:%q", n, buf[:n])
             for i:=0; i<n; i++ {
                     // log.Printf("pushing %q", buf[i])
-                    read_byte <- buf[i]
+                    select {
+                        case read_byte <- buf[i]:
+                        case <-done:
+                            return
+                    }
             }
             // log.Printf("Reader BOTTOM of loop")
         }
@@ -119,6 +200,144 @@ func (bp *BP) Init() error {
     return nil
 } //Init()
 
+// acquire locks the BP, unless this handle already holds it. It returns a
+// handle that holds it, and the func to release it.
+func (bp *BP) acquire() (*BP, func()) {
+
+    if bp.held {
+        return bp, func() {}
+    }
+
+    bp.mu.Lock()
+    tx := &BP{bpConn: bp.bpConn, held: true}
+
+    return tx, func() {
+        tx.held = false
+        bp.mu.Unlock()
+    }
+} //acquire()
+
+// Lock takes the BP for a sequence of commands and returns a handle that
+// holds it. Use only that handle until its Unlock, anything else on the BP
+// waits until then. Lock on a handle that already holds the BP returns that
+// same handle, and its Unlock is matched to the Lock.
+func (bp *BP) Lock() *BP {
+
+    tx, unlock := bp.acquire()
+    if tx == bp {
+        bp.nested++
+        return bp
+    }
+
+    tx.release = unlock
+    return tx
+} //Lock()
+
+// Unlock releases a handle from Lock, or undoes a nested Lock.
+func (bp *BP) Unlock() {
+
+    if bp.nested > 0 {
+        bp.nested--
+        return
+    }
+    if bp.release == nil {
+        return
+    }
+
+    release := bp.release
+    bp.release = nil
+    release()
+} //Unlock()
+
+// Transaction runs f with a handle that holds the BP, so nothing from other
+// goroutines gets in between its commands.
+func (bp *BP) Transaction(f func(tx *BP) error) error {
+
+    tx, unlock := bp.acquire()
+    defer unlock()
+
+    return f(tx)
+} //Transaction()
+
+func (bp *BP) isClosed() bool {
+    select {
+        case <-bp.done:
+            return true
+        default:
+            return false
+    }
+} //isClosed()
+
+// safeState turns off the power supply and pull-ups and goes back to the
+// terminal. It is best effort, failures are only logged.
+func (bp *BP) safeState() {
+
+    if bp.state != STATE_BINARY {
+        // Already in the terminal, or bridged/unknown and unreachable.
+        return
+    }
+
+    var err error
+    if bp.mode == MODE_BITBANG {
+        _, err = bp.writeReadN([]uint8{SET_PINS_HIGH_LOW}, 1)
+        bp.pins_high_low = 0
+    } else {
+        err = bp.modeWriteOK(bp.mode, []uint8{SET_PERIPH})
+    }
+    if err != nil {
+        log.Printf("Close: unable to turn off peripherals: %s", err)
+    }
+
+    if err := bp.Reset(); err != nil {
+        log.Printf("Close: unable to reset: %s", err)
+    }
+} //safeState()
+
+// Close turns the peripherals off and puts the Bus Pirate back in the
+// terminal, then stops the reader and closes the Transport. Everything after
+// Close fails with ErrClosed.
+func (bp *BP) Close() error {
+
+    bp, unlock := bp.acquire()
+    defer unlock()
+
+    if bp.isClosed() {
+        return ErrClosed
+    }
+
+    if bp.reader_done != nil {
+        bp.safeState()
+    }
+    close(bp.done)
+
+    if bp.Transport == nil {
+        return nil
+    }
+    err := bp.Transport.Close()
+
+    // Closing the Transport should have woken the reader up.
+    if bp.reader_done != nil {
+        select {
+            case <-bp.reader_done:
+            case <-time.After(CLOSE_TIMEOUT * time.Millisecond):
+                log.Printf("Close: reader has not stopped")
+        }
+    }
+
+    log.Printf("Closed.")
+    return err
+} //Close()
+
+// write sends data, unless the BP has been closed.
+func (bp *BP) write(data []uint8) (int, error) {
+
+    if bp.isClosed() {
+        return 0, ErrClosed
+    }
+
+    return bp.Transport.Write(data)
+} //write()
+
 func (bp *BP) strBytesCmp(inb []uint8, str string) bool {
 
     if len(str) > len(inb) {
@@ -141,56 +360,184 @@ func (bp *BP) isBB(inb []uint8) bool {
     return bp.strBytesCmp(inb, MODE_BB_REPLY)
 } //isBB()
 
-func (bp *BP) readBytes() ([]uint8, error) {
+// isPrompt is true once inb ends in a terminal prompt.
+func (bp *BP) isPrompt(inb []uint8) bool {
+    return strings.HasSuffix(string(inb), MODE_HIZ_PROMPT)
+} //isPrompt()
+
+// isBBEnd is true once inb ends in the bitbang mode reply.
+func (bp *BP) isBBEnd(inb []uint8) bool {
+    return strings.HasSuffix(string(inb), MODE_BB_REPLY)
+} //isBBEnd()
+
+// readContext returns a context with the ReadTimeout deadline, plus the time
+// n bytes take on the serial line, or no deadline at all when ReadTimeout is 0.
+func (bp *BP) readContext(n int) (context.Context, context.CancelFunc) {
 
-    blen := len(bp.read_byte)
-    var err error = nil
+    if bp.ReadTimeout <= 0 {
+        return context.WithCancel(context.Background())
+    }
+
+    timeout := bp.ReadTimeout + time.Duration(n) * SERIAL_BYTE_TIMEOUT * time.Microsecond
+    return context.WithTimeout(context.Background(), timeout)
+} //readContext()
+
+// readByte blocks for a single byte, until ctx is done, or the transport
+// fails.
+func (bp *BP) readByte(ctx context.Context) (uint8, error) {
+
+    if bp.isClosed() {
+        return 0, ErrClosed
+    }
 
+    // Prefer waiting bytes over a pending read error or deadline.
     select {
-        case err = <-bp.read_err:
-            log.Printf("read error: %s", err)
+        case b := <-bp.read_byte:
+            return b, nil
         default:
     }
 
-    res := make([]uint8, blen)
-
-    if blen < 1 {
-        return res, err
+    select {
+        case b := <-bp.read_byte:
+            return b, nil
+        case err := <-bp.read_err:
+            // Leave the error for the next reader too.
+            bp.read_err <- err
+            if err == nil {
+                err = io.EOF
+            }
+            return 0, err
+        case <-bp.done:
+            return 0, ErrClosed
+        case <-ctx.Done():
+            if ctx.Err() == context.DeadlineExceeded {
+                return 0, ErrTimeout
+            }
+            return 0, ctx.Err()
     }
+} //readByte()
 
-    for i:=0; i<blen; i++ {
-        res[i] = <-bp.read_byte
+// ReadExactly blocks until n bytes have been read, or ctx is done. On a
+// deadline the error is ErrTimeout and the bytes read so far are returned.
+func (bp *BP) ReadExactly(ctx context.Context, n int) ([]uint8, error) {
+
+    bp, unlock := bp.acquire()
+    defer unlock()
+
+    res := make([]uint8, 0, n)
+    for len(res) < n {
+        b, err := bp.readByte(ctx)
+        if err == ErrTimeout {
+            return res, fmt.Errorf("%w, expected %d bytes, got: %q", ErrTimeout, n, res)
+        }
+        if err != nil {
+            return res, err
+        }
+        res = append(res, b)
     }
 
-    return res, err
-} //readBytes()
+    return res, nil
+} //ReadExactly()
 
-func (bp *BP) ReadNB() ([]uint8, error) {
-        // log.Printf("ReadNB start...\n")
+// ReadUntil reads until pred returns true for everything read so far, or ctx
+// is done.
+func (bp *BP) ReadUntil(ctx context.Context, pred func([]uint8) bool) ([]uint8, error) {
+
+    bp, unlock := bp.acquire()
+    defer unlock()
 
-        res, err := bp.readBytes()
+    var res []uint8
+    for {
+        b, err := bp.readByte(ctx)
+        if err == ErrTimeout {
+            return res, fmt.Errorf("%w, got: %q", ErrTimeout, res)
+        }
         if err != nil {
-           return res, err
+            return res, err
         }
 
-        if len(res) > 0 {
+        res = append(res, b)
+        if pred(res) {
             return res, nil
         }
+    }
+} //ReadUntil()
 
-        // If we're using a read timeout, wait for the
-        // timeout period and try to get more bytes later.
-        if bp.ReadTimeout > 0 {
-            // log.Printf("ReadNB second read\n")
-            time.Sleep(bp.ReadTimeout)
-            res2, err2 := bp.readBytes()
-            res = append(res, res2...)
-            if err2 != nil {
-                return res, err2
-            }
+// readQuiet reads until nothing new has arrived for quiet.
+func (bp *BP) readQuiet(quiet time.Duration) ([]uint8, error) {
+
+    var res []uint8
+    for {
+        ctx, cancel := context.WithTimeout(context.Background(), quiet)
+        b, err := bp.readByte(ctx)
+        cancel()
+        if err == ErrTimeout {
+            return res, nil
         }
+        if err != nil {
+            return res, err
+        }
+        res = append(res, b)
+    }
+} //readQuiet()
 
+// drain throws away anything still arriving, like the rest of a banner.
+func (bp *BP) drain() {
+    bytes, _ := bp.readQuiet(QUIET_TIMEOUT * time.Millisecond)
+    if len(bytes) > 0 {
+        log.Printf("drained: %q", bytes)
+    }
+} //drain()
+
+// readStream blocks until at least one byte has been read, then returns as
+// many more as are already waiting, up to len(p).
+func (bp *BP) readStream(p []uint8) (int, error) {
+
+    if len(p) == 0 {
+        return 0, nil
+    }
+
+    b, err := bp.readByte(context.Background())
+    if err != nil {
+        return 0, err
+    }
+    p[0] = b
+
+    n := 1
+    for n < len(p) {
+        select {
+            case p[n] = <-bp.read_byte:
+                n++
+            default:
+                return n, nil
+        }
+    }
+
+    return n, nil
+} //readStream()
+
+// ReadNB is for replies of unknown length. It waits up to ReadTimeout for a
+// reply to start, then returns everything that arrives until the line goes
+// quiet. Nothing arriving at all is not an error.
+func (bp *BP) ReadNB() ([]uint8, error) {
+
+    bp, unlock := bp.acquire()
+    defer unlock()
+
+        ctx, cancel := bp.readContext(0)
+        defer cancel()
+
+        res, err := bp.ReadExactly(ctx, 1)
+        if errors.Is(err, ErrTimeout) {
+            return res, nil
+        }
+        if err != nil {
+            return res, err
+        }
+
+        more, err := bp.readQuiet(QUIET_TIMEOUT * time.Millisecond)
         // log.Printf("ReadNB bottom: %q\n", res)
-        return res, nil
+        return append(res, more...), err
 } //ReadNB()
 
 
@@ -198,13 +545,15 @@ func (bp *BP) ReadNB() ([]uint8, error) {
 // result
 func (bp *BP) WriteReadCHK(data []uint8, chk string) ([]uint8, bool, error) {
 
+    bp, unlock := bp.acquire()
+    defer unlock()
+
         // log.Printf("WriteReadCHK data:%q, chk:%q\n", data, chk)
         found := false
 
         bytes, err := bp.WriteRead(data)
         if err != nil {
-            log.Fatal(err)
-            return nil, found, err
+            return bytes, found, err
         }
 
         // If chk is empty, always return a find.
@@ -216,36 +565,126 @@ func (bp *BP) WriteReadCHK(data []uint8, chk string) ([]uint8, bool, error) {
         return bytes, found, nil
 }
 
+// writeFind writes data and expects chk as the reply.
 func (bp *BP) writeFind(data []uint8, chk string) ([]uint8, error) {
-    // A wrapper to simplify use of WriteReadCHK
 
-    bytes, found, err := bp.WriteReadCHK(data, chk)
+    bytes, err := bp.writeReadN(data, len(chk))
     if err != nil {
         return bytes, err
     }
 
-    if found {
-        return bytes, nil
+    if !bp.strBytesCmp(bytes, chk) {
+        return bytes, &ErrUnexpectedReply{Want: []uint8(chk), Got: bytes}
     }
 
-    return bytes, errors.New(fmt.Sprintf("Unable to find chk string: %q", chk))
+    return bytes, nil
 } //writeFind()
 
+// writeReadCtx writes data and reads an n byte reply, or until ctx is done.
+func (bp *BP) writeReadCtx(ctx context.Context, data []uint8, n int) ([]uint8, error) {
+
+    bp, unlock := bp.acquire()
+    defer unlock()
+
+    _, err := bp.write(data)
+    if err != nil {
+        return nil, err
+    }
+
+    return bp.ReadExactly(ctx, n)
+} //writeReadCtx()
+
+// writeReadN writes data and reads an n byte reply within ReadTimeout, plus
+// the time the reply takes to arrive.
+func (bp *BP) writeReadN(data []uint8, n int) ([]uint8, error) {
+
+    ctx, cancel := bp.readContext(n)
+    defer cancel()
+
+    return bp.writeReadCtx(ctx, data, n)
+} //writeReadN()
+
+// writeUntil writes data and reads until pred is happy, within ReadTimeout.
+func (bp *BP) writeUntil(data []uint8, pred func([]uint8) bool) ([]uint8, error) {
+
+    bp, unlock := bp.acquire()
+    defer unlock()
+
+    _, err := bp.write(data)
+    if err != nil {
+        return nil, err
+    }
+
+    ctx, cancel := bp.readContext(0)
+    defer cancel()
+
+    return bp.ReadUntil(ctx, pred)
+} //writeUntil()
+
+// checkMode returns ErrNotInMode unless mode is the binary mode the BP is
+// currently in.
+func (bp *BP) checkMode(mode uint8) error {
+
+    if bp.isClosed() {
+        return ErrClosed
+    }
+
+    if bp.state != STATE_BINARY || bp.mode != mode {
+        return ErrNotInMode
+    }
+
+    if mode == MODE_UART && bp.uart_echo {
+        return ErrUARTEcho
+    }
+
+    return nil
+} //checkMode()
+
+// modeWriteRead is writeReadN for a command that only makes sense in mode.
+func (bp *BP) modeWriteRead(mode uint8, data []uint8, n int) ([]uint8, error) {
+
+    bp, unlock := bp.acquire()
+    defer unlock()
+
+    if err := bp.checkMode(mode); err != nil {
+        return nil, err
+    }
+
+    return bp.writeReadN(data, n)
+} //modeWriteRead()
+
+// modeWriteOK sends a command, in mode, that answers a single 0x01 on
+// success.
+func (bp *BP) modeWriteOK(mode uint8, data []uint8) error {
+
+    res, err := bp.modeWriteRead(mode, data, 1)
+    if err != nil {
+        return err
+    }
+
+    if res[0] != 0x01 {
+        return &ErrUnexpectedReply{Want: []uint8{0x01}, Got: res}
+    }
+
+    return nil
+} //modeWriteOK()
+
 func (bp *BP) WriteRead(data []uint8) ([]uint8, error) {
 
+    bp, unlock := bp.acquire()
+    defer unlock()
+
     // log.Printf("WriteRead, writing: %X:%q", data, data)
 
-    // n, err := bp.Serial.Write(data)
-    _, err := bp.Serial.Write(data)
+    // n, err := bp.Transport.Write(data)
+    _, err := bp.write(data)
     if err != nil {
-        log.Fatal(err)
         return nil, err
     }
     // log.Printf("WriteRead n:%d, len(data):%d", n, len(data))
 
     bytes, err := bp.ReadNB()
     if err != nil {
-        log.Fatal(err)
         return bytes, err
     }
 
@@ -256,6 +695,9 @@ func (bp *BP) WriteRead(data []uint8) ([]uint8, error) {
 
 func (bp *BP) HWReset() error {
 
+    bp, unlock := bp.acquire()
+    defer unlock()
+
     log.Printf("HWReset, attempting Binary mode.")
 
     // Try to get into BB mode and do a HW reset.
@@ -269,33 +711,37 @@ func (bp *BP) HWReset() error {
     // Now that we're in BB mode, try a HW reset
     // We use a long time to give the board plenty of reset time.
     log.Printf("HWReset...")
-    bp.ReadTimeout = 500 * time.Millisecond
-    _, err = bp.writeFind([]uint8{HW_RESET}, string(HW_RESET_REPLY))
-    bp.ReadTimeout = DEFAULT_TIMEOUT * time.Millisecond
-
-    if err == nil {
-        bp.state = STATE_INITIAL
-    }
-
-    // log.Printf("HWReset, buffer after HW reset:%q.", bytes)
-    // if string(bytes[0]) == "\a" {
-        // We might already be at console mode, let's s
-        log.Printf("HWReset: attempting double reset.")
-        // do it twice, to eat up the startup text
-        log.Printf("HWReset 1!")
-        bp.state = STATE_INITIAL
-        log.Printf("HWReset 2!")
-        bp.state = STATE_INITIAL
-        bp.Reset()
-        bp.state = STATE_INITIAL
-        err = bp.Reset()
-    // }
+    ctx, cancel := context.WithTimeout(context.Background(),
+        HW_RESET_TIMEOUT * time.Millisecond)
+    defer cancel()
+
+    bytes, err := bp.writeReadCtx(ctx, []uint8{HW_RESET}, 1)
+    if err != nil {
+        bp.state = 0
+        return err
+    }
+    if bytes[0] != HW_RESET_REPLY {
+        bp.state = 0
+        return &ErrUnexpectedReply{Want: []uint8{HW_RESET_REPLY}, Got: bytes}
+    }
+
+    // Eat up the startup text, through to the first prompt.
+    bytes, err = bp.ReadUntil(ctx, bp.isPrompt)
+    if err != nil {
+        log.Printf("HWReset, no prompt after the banner: %q", bytes)
+    }
+
+    bp.state = STATE_INITIAL
+    err = bp.Reset()
 
     log.Printf("HWReset: done:%s", err)
     return err
 } //HWReset()
 
 func (bp *BP) Reset() error {
+    bp, unlock := bp.acquire()
+    defer unlock()
+
     log.Printf("Reset")
 
     // Are we already Reset?
@@ -312,15 +758,6 @@ func (bp *BP) Reset() error {
         }
     }
 
-    if bp.state == STATE_RAW {
-        log.Printf("In raw mode state, going to reset HW")
-        err := bp.HWReset()
-        if err != nil {
-            log.Printf("Reset: Unable to Hardware reset.")
-            return err
-        }
-    }
-
     if bp.state == 0 {
         log.Printf("In UNKNOWN mode state, going to reset HW")
         err := bp.HWReset()
@@ -331,69 +768,45 @@ func (bp *BP) Reset() error {
     }
 
     // Reset the BP
-    // Send 10 <enter> and one '#'
-    rst_bits := []uint8{
-        0x0D, 0x0D, 0x0D, 0x0D, 0x0D,
-        0x0D, 0x0D, 0x0D, 0x0D, 0x0D }
-
+    // Send up to 10 <enter> and then one '#'
     var bytes []uint8
+    var err error
 
-    for k, _ := range rst_bits {
+    for k := 0; k < 10; k++ {
 
-        bytes, found, err := bp.WriteReadCHK(rst_bits[k:k+1], MODE_HIZ_REPLY1)
-
-        if err != nil {
-            log.Fatal(err)
-            return err
-        }
-
-        if found {
-            log.Printf("Reset, 10 enters, Reset Good!")
+        bytes, err = bp.writeUntil([]uint8{0x0D}, bp.isPrompt)
+        if err == nil {
+            log.Printf("Reset, %d enters, Reset Good!", k + 1)
             bp.state = STATE_INITIAL
             return nil
-        } else {
-            // maybe we got hizp? Kind of weird.
-            if bp.isHiz(bytes) {
-                    log.Printf("Reset, 10 enters, Reset Good!")
-                    bp.state = STATE_INITIAL
-                    return nil
-            }
-            log.Printf("Reset, looking for %q, got: %q",
-                        MODE_HIZ_REPLY2, bytes)
-            // We may be in BB mode, let's check
-            if bp.isBB(bytes) {
-                log.Printf("We're in Binary mode already!")
-                bp.state = STATE_INITIAL
-                return nil
-            }
         }
-    }
+        if !errors.Is(err, ErrTimeout) {
+            return err
+        }
 
-    // Are we in binary mode?
-    if bp.isBB(bytes) {
-        log.Printf("Reset, 10 enters, Reset Good!")
-        bp.state = STATE_INITIAL
-        return nil
+        log.Printf("Reset, looking for %q, got: %q", MODE_HIZ_REPLY1, bytes)
     }
 
-    bytes, err := bp.WriteRead([]uint8{'#', 0x0D})
-    found := bp.isHiz(bytes)
-
-    if err != nil || !found{
-        log.Fatal(err)
-        log.Printf(
-            "Reset #, looking for HiZ>, got: %q\n Don't expect this to work!\n",
-            bytes)
+    bytes, err = bp.writeUntil([]uint8{'#'}, bp.isPrompt)
+    if errors.Is(err, ErrTimeout) {
+        log.Printf("Reset #, looking for HiZ>, got: %q\n", bytes)
+        return &ErrUnexpectedReply{Want: []uint8(MODE_HIZ_REPLY1), Got: bytes}
+    }
+    if err != nil {
         return err
     }
 
-    log.Printf("Reset, 10 enters, Reset Good!")
+    log.Printf("Reset #, Reset Good!")
+    bp.drain()
     bp.state = STATE_INITIAL
     return nil
 } //Reset()
 
 func (bp *BP) BinaryMode() error {
 
+    bp, unlock := bp.acquire()
+    defer unlock()
+
     if bp.state == STATE_BINARY {
         log.Printf("BinaryMode: Already in binary mode, not going to reset.")
     } else {
@@ -406,137 +819,310 @@ func (bp *BP) BinaryMode() error {
         }
     }
 
-    // Enable Binary mode
-    bm := []uint8{0, 0, 0, 0, 0, 0,
-                  0, 0, 0, 0, 0, 0,
-                  0, 0, 0, 0, 0, 0,
-                  0, 0, 0, 0, 0, 0 }
-    for k, _ := range bm {
-        _, err := bp.writeFind(bm[k:k+1], MODE_BB_REPLY)
+    // Already in a binary mode, a single 0x00 gets us back to bitbang.
+    if bp.state == STATE_BINARY {
+        _, err := bp.writeFind([]uint8{BINARY_RESET}, MODE_BB_REPLY)
         if err == nil {
             log.Printf("Entered Binary mode")
-            bp.state = STATE_BINARY
+            bp.enterBitbang()
             return nil
         }
+        log.Printf("BinaryMode: no reply to one 0x00: %s", err)
     }
 
-    err := errors.New("Unable to enter binary mode")
-    log.Fatal(err)
-    return err
-} //BinaryMode()
-
-func (bp *BP) writePinsHL(chk string) error {
-    log.Printf("writePinsHL: pins_high_low: %x\n", bp.pins_high_low)
-    _, err := bp.writeFind([]byte{SET_PINS_HIGH_LOW | bp.pins_high_low}, chk)
+    // From the terminal it takes 20 0x00s. Any extra past that are answered
+    // with another BBIO1, so eat those.
+    bm := make([]uint8, BINARY_MODE_ZEROS)
+    _, err := bp.writeUntil(bm, bp.isBBEnd)
     if err != nil {
-        log.Printf("Unable set Peripheral mask: %x\n", bp.pins_high_low)
+        log.Printf("Unable to enter binary mode: %s", err)
         return err
     }
+    bp.drain()
 
+    log.Printf("Entered Binary mode")
+    bp.state = STATE_BINARY
+    bp.enterBitbang()
     return nil
-} //writePinsHL(chk string)()
+} //BinaryMode()
 
-func (bp *BP) writePinsIO(chk string) error {
-    log.Printf("writePinsIO: pins_in_out: %x\n", bp.pins_in_out)
-    _, err := bp.writeFind([]byte{SET_PINS_IN_OUT | bp.pins_in_out}, chk)
-    if err != nil {
-        log.Printf("Unable set Peripheral mask: %x\n", bp.pins_in_out)
-        return err
-    }
+// enterBitbang records that the Bus Pirate is in bitbang mode, where it starts
+// with every pin an input and every output low.
+func (bp *BP) enterBitbang() {
+    bp.mode = MODE_BITBANG
+    bp.pins_in_out = uint8(PINS_IO)
+    bp.pins_high_low = 0
+} //enterBitbang()
 
-    return nil
-} //writePinsIO(chk string)()
+// selfTest runs the HW_TEST_SHORT or HW_TEST_LONG self-test, then sends 0xFF
+// to get back to bitbang mode. It fails if the test found any errors.
+func (bp *BP) selfTest(cmd uint8) error {
 
-func (bp *BP) SetPinsHigh(mask uint8, chk string) error {
+    bp, unlock := bp.acquire()
+    defer unlock()
 
-    bp.pins_high_low |= mask
-    err := bp.writePinsHL(chk)
-    if err != nil {
-        bp.pins_high_low &= (0xFF ^ mask)
+    if err := bp.checkMode(MODE_BITBANG); err != nil {
         return err
     }
 
-    return nil
-} //SetPinsHigh()
-
-func (bp *BP) SetPinsLow(mask uint8, chk string) error {
+    // The reply is the number of errors found.
+    ctx, cancel := context.WithTimeout(context.Background(),
+        SELF_TEST_TIMEOUT * time.Millisecond)
+    defer cancel()
+    res, err := bp.writeReadCtx(ctx, []uint8{cmd}, 1)
+    if err != nil {
+        return err
+    }
+    errs := res[0]
 
-    bp.pins_high_low &= (0xFF ^ mask)
-    err := bp.writePinsHL(chk)
+    // The self-test keeps replying with the error count until 0xFF.
+    res, err = bp.writeReadN([]uint8{SELF_TEST_EXIT}, 1)
     if err != nil {
-        bp.pins_high_low |= mask
         return err
     }
+    if res[0] != 0x01 {
+        return &ErrUnexpectedReply{Want: []uint8{0x01}, Got: res}
+    }
+
+    if errs != 0 {
+        return errors.New(fmt.Sprintf("Self-test found %d errors", errs))
+    }
 
     return nil
-} //SetPinsLow()
+} //selfTest()
+
+// ShortTest runs the short self-test, which needs no jumpers.
+func (bp *BP) ShortTest() error {
+    log.Printf("ShortTest")
+    return bp.selfTest(HW_TEST_SHORT)
+} //ShortTest()
 
-func (bp *BP) SetPinsIn(mask uint8, chk string) error {
+// LongTest runs the long self-test, which needs the self-test jumpers
+// (ADC to +5V, Vpu to +3.3V) in place.
+func (bp *BP) LongTest() error {
+    log.Printf("LongTest")
+    return bp.selfTest(HW_TEST_LONG)
+} //LongTest()
 
-    bp.pins_in_out |= mask
-    err := bp.writePinsIO(chk)
-    if err != nil {
-        bp.pins_in_out &= (0xFF ^ mask)
-        return err
+// The timer 2 prescaler divisors, in SET_PWM's order.
+var pwmPrescalers = []int{1, 8, 64, 256}
+
+// SetPWM starts the PWM output on the AUX pin at freq Hz, high for duty
+// percent of each period. Not every frequency and duty cycle can be made, so
+// it returns those the hardware actually gives.
+func (bp *BP) SetPWM(freq float64, duty float64) (float64, float64, error) {
+
+    if freq <= 0 || duty < 0 || duty > 100 {
+        return 0, 0, errors.New(fmt.Sprintf("Invalid PWM, %vHz at %v%%", freq, duty))
     }
 
-    return nil
-} //SetPinsIn()
+    // The smallest prescaler whose period fits, for the finest steps. The
+    // period stops short of 0xFFFF so a 100% duty cycle, period + 1, still
+    // fits in 16 bits.
+    pre, period := -1, 0
+    for i, div := range pwmPrescalers {
+        p := math.Round(PWM_FCY / (float64(div) * freq)) - 1
+        if p >= 1 && p <= 0xFFFE {
+            pre, period = i, int(p)
+            break
+        }
+    }
+    if pre < 0 {
+        return 0, 0, errors.New(fmt.Sprintf("PWM frequency out of range: %vHz", freq))
+    }
 
-func (bp *BP) SetPinsOut(mask uint8, chk string) error {
+    cycle := int(math.Round(float64(period + 1) * duty / 100))
 
-    bp.pins_in_out &= (0xFF ^ mask)
-    err := bp.writePinsIO(chk)
-    if err != nil {
-        bp.pins_in_out |= mask
-        return err
+    bp, unlock := bp.acquire()
+    defer unlock()
+
+    if err := bp.checkMode(MODE_BITBANG); err != nil {
+        return 0, 0, err
     }
 
-    return nil
-} //SetPinsOut()
+    res, err := bp.writeReadN([]uint8{SET_PWM, uint8(pre),
+        uint8(cycle >> 8), uint8(cycle), uint8(period >> 8), uint8(period)}, 1)
+    if err != nil {
+        return 0, 0, err
+    }
+    if res[0] != 0x01 {
+        return 0, 0, &ErrUnexpectedReply{Want: []uint8{0x01}, Got: res}
+    }
 
-func (bp *BP) ShortTest() error {
+    got_freq := PWM_FCY / (float64(pwmPrescalers[pre]) * float64(period + 1))
+    got_duty := float64(cycle) * 100 / float64(period + 1)
+    return got_freq, got_duty, nil
+} //SetPWM()
 
-    log.Printf("ShortTest")
-    // HW_TEST_SHORT
-    bytes, err := bp.writeFind([]byte{HW_TEST_SHORT}, "")
-    fmt.Printf("ShortTest output: %s\n", bytes)
+// ClearPWM stops the PWM output.
+func (bp *BP) ClearPWM() error {
 
-    return err
-} //ShortTest()
+    bp, unlock := bp.acquire()
+    defer unlock()
 
-func (bp *BP) LongTest() error {
+    if err := bp.checkMode(MODE_BITBANG); err != nil {
+        return err
+    }
 
-    log.Printf("LongTest")
-    // HW_TEST_SHORT
-    bytes, err := bp.writeFind([]byte{HW_TEST_LONG}, "")
-    fmt.Printf("LongTest output: %s\n", bytes)
+    res, err := bp.writeReadN([]uint8{CLEAR_PWM}, 1)
+    if err != nil {
+        return err
+    }
+    if res[0] != 0x01 {
+        return &ErrUnexpectedReply{Want: []uint8{0x01}, Got: res}
+    }
 
-    return err
-} //LongTest()
+    return nil
+} //ClearPWM()
 
 func (bp *BP) GetMode() (string, error) {
 
-    bytes, err := bp.writeFind([]byte{GET_MODE}, "")
+    bp, unlock := bp.acquire()
+    defer unlock()
+
+    // In bitbang 0x01 would enter SPI, a 0x00 just answers BBIO1 again.
+    var bytes []uint8
+    var err error
+    if bp.checkMode(MODE_BITBANG) == nil {
+        bytes, err = bp.writeReadN([]byte{BINARY_RESET}, len(MODE_BB_REPLY))
+    } else {
+        bytes, err = bp.writeReadN([]byte{GET_MODE}, len(MODE_I2C_REPLY))
+    }
     res := make([]uint8, len(bytes))
     copy(res, bytes)
 
     return string(res), err
 } //GetMode()
 
+// enterMode records mode as entered, with the settings the Bus Pirate starts
+// it with.
+func (bp *BP) enterMode(mode uint8) {
+
+    bp.mode = mode
+    bp.periph[mode] = 0
+    bp.speed[mode] = 0
+    bp.config[mode] = 0
+
+    switch mode {
+        case MODE_SPI:
+            bp.config[mode] = SPI_CONF_DEFAULT
+        case MODE_UART:
+            bp.uart_baud = 0
+            bp.uart_echo = false
+    }
+} //enterMode()
+
 func (bp *BP) ModeI2C() (*I2C, error) {
 
+    bp, unlock := bp.acquire()
+    defer unlock()
+
     i2c := NewI2C(bp)
     // i2c.Init()
     // Make sure we're in Binary Mode
-    bp.BinaryMode()
+    if err := bp.BinaryMode(); err != nil {
+        return i2c, err
+    }
 
     // log.Printf("The Buffer: %q\n", bytes)
     _, err := bp.writeFind([]byte{MODE_I2C}, MODE_I2C_REPLY)
     if err != nil {
         return i2c, err
     }
+    speed := bp.speed[MODE_I2C]
+    bp.enterMode(MODE_I2C)
+
+    // The Bus Pirate starts I2C mode at ~5kHz, put back the speed last set.
+    if speed != I2C_SPEED_5 {
+        if err := i2c.SetSpeed(I2CSpeed(speed)); err != nil {
+            return i2c, err
+        }
+    }
 
     log.Printf("Entered I2C mode.")
     return i2c, nil
 } //ModeI2C()
+
+func (bp *BP) ModeSPI() (*SPI, error) {
+
+    bp, unlock := bp.acquire()
+    defer unlock()
+
+    spi := NewSPI(bp)
+    // Make sure we're in Binary Mode
+    if err := bp.BinaryMode(); err != nil {
+        return spi, err
+    }
+
+    _, err := bp.writeFind([]byte{MODE_SPI}, MODE_SPI_REPLY)
+    if err != nil {
+        return spi, err
+    }
+    bp.enterMode(MODE_SPI)
+
+    log.Printf("Entered SPI mode.")
+    return spi, nil
+} //ModeSPI()
+
+func (bp *BP) ModeUART() (*UART, error) {
+
+    bp, unlock := bp.acquire()
+    defer unlock()
+
+    u := NewUART(bp)
+    // Make sure we're in Binary Mode
+    if err := bp.BinaryMode(); err != nil {
+        return u, err
+    }
+
+    _, err := bp.writeFind([]byte{MODE_UART}, MODE_UART_REPLY)
+    if err != nil {
+        return u, err
+    }
+    bp.enterMode(MODE_UART)
+
+    log.Printf("Entered UART mode.")
+    return u, nil
+} //ModeUART()
+
+func (bp *BP) ModeOneWire() (*OneWire, error) {
+
+    bp, unlock := bp.acquire()
+    defer unlock()
+
+    ow := NewOneWire(bp)
+    // Make sure we're in Binary Mode
+    if err := bp.BinaryMode(); err != nil {
+        return ow, err
+    }
+
+    _, err := bp.writeFind([]byte{MODE_1WIRE}, MODE_1WIRE_REPLY)
+    if err != nil {
+        return ow, err
+    }
+    bp.enterMode(MODE_1WIRE)
+
+    log.Printf("Entered 1-Wire mode.")
+    return ow, nil
+} //ModeOneWire()
+
+func (bp *BP) ModeRawWire() (*RawWire, error) {
+
+    bp, unlock := bp.acquire()
+    defer unlock()
+
+    raw := NewRawWire(bp)
+    // Make sure we're in Binary Mode
+    if err := bp.BinaryMode(); err != nil {
+        return raw, err
+    }
+
+    _, err := bp.writeFind([]byte{MODE_RAW}, MODE_RAW_REPLY)
+    if err != nil {
+        return raw, err
+    }
+    bp.enterMode(MODE_RAW)
+
+    log.Printf("Entered raw-wire mode.")
+    return raw, nil
+} //ModeRawWire()
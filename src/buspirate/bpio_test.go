@@ -1,7 +1,13 @@
 
 package buspirate
 
-import "testing"
+import (
+    "context"
+    "errors"
+    "net"
+    "time"
+    "testing"
+)
 
 func TestNewBP (t *testing.T) {
 
@@ -19,31 +25,601 @@ func TestNewBP (t *testing.T) {
     dev := "/dev/ttyUSB0"
     nbp = NewBP(dev)
     if nbp.Device != dev {
-        t.Fatalf("Invalid buspirate Device: %s, expected: ", nbp.Device, dev)
+        t.Fatalf("Invalid buspirate Device: %s, expected: %s", nbp.Device, dev)
+    }
+    if conf := nbp.SerialConf(); conf == nil || conf.Name != dev || conf.Baud != BAUD {
+        t.Fatalf("Invalid SerialConf: %+v", conf)
+    }
+    if nbp.Serial() != nil {
+        t.Fatalf("Serial set before Init")
     }
 } //TestNewBP()
 
 func TestBPInit (t *testing.T) {
 
-    nbp := NewBP("")
+    host, _ := NewPipe()
+    nbp := NewBPWithTransport(host)
     if nil == nbp {
         t.Fatalf("Unable to allocate an Buspirate IO instance")
     }
 
-    // Make sure we have a Serial object
     err := nbp.Init()
     if err != nil {
-        t.Fail()
-        t.Logf("Unable to initialize a Buspirate IO instance\n%s", err)
-        return
+        t.Fatalf("Unable to initialize a Buspirate IO instance\n%s", err)
     }
 
-    if nbp.Serial == nil {
-        t.Fatalf("Unable to initialize a Buspirate Serial instance")
+    if nbp.Transport != host {
+        t.Fatalf("Init replaced the Buspirate Transport")
+    }
+    if nbp.Serial() != host || nbp.SerialConf() != nil {
+        t.Fatalf("Serial and SerialConf don't match the Transport")
     }
 
     // make sure the buffer is the correct size
-    if len(nbp.buf) != BUF_SIZE {
-        t.Fatalf("Expected a buf size of %d, got %d", BUF_SIZE, len(nbp.buf))
+    if len(nbp.read_buf) != READ_BUF_SIZE {
+        t.Fatalf("Expected a buf size of %d, got %d", READ_BUF_SIZE, len(nbp.read_buf))
     }
 } //TestBPInit()
+
+// echoTransport answers every write on far with the same bytes.
+func echoTransport(far Transport) {
+    go func() {
+        buf := make([]uint8, 64)
+        for {
+            n, err := far.Read(buf)
+            if err != nil {
+                return
+            }
+            far.Write(buf[:n])
+        }
+    }()
+} //echoTransport()
+
+func TestWriteReadPipe (t *testing.T) {
+
+    host, far := NewPipe()
+    defer far.Close()
+    echoTransport(far)
+
+    nbp := NewBPWithTransport(host)
+    if err := nbp.Init(); err != nil {
+        t.Fatalf("Unable to initialize a Buspirate IO instance\n%s", err)
+    }
+
+    bytes, err := nbp.WriteRead([]uint8("ping"))
+    if err != nil {
+        t.Fatalf("WriteRead failed: %s", err)
+    }
+    if string(bytes) != "ping" {
+        t.Fatalf("Expected %q, got %q", "ping", bytes)
+    }
+} //TestWriteReadPipe()
+
+func TestWriteReadTCP (t *testing.T) {
+
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Skipf("Unable to listen on localhost: %s", err)
+    }
+    defer ln.Close()
+
+    go func() {
+        conn, err := ln.Accept()
+        if err != nil {
+            return
+        }
+        echoTransport(conn)
+    }()
+
+    tr, err := DialTCP(ln.Addr().String())
+    if err != nil {
+        t.Fatalf("DialTCP failed: %s", err)
+    }
+    defer tr.Close()
+
+    nbp := NewBPWithTransport(tr)
+    if err := nbp.Init(); err != nil {
+        t.Fatalf("Unable to initialize a Buspirate IO instance\n%s", err)
+    }
+
+    bytes, err := nbp.WriteRead([]uint8("pong"))
+    if err != nil {
+        t.Fatalf("WriteRead failed: %s", err)
+    }
+    if string(bytes) != "pong" {
+        t.Fatalf("Expected %q, got %q", "pong", bytes)
+    }
+} //TestWriteReadTCP()
+
+func TestReadExactly (t *testing.T) {
+
+    near, far := NewPipe()
+    nbp := NewBPWithTransport(near)
+    if err := nbp.Init(); err != nil {
+        t.Fatalf("Unable to initialize a Buspirate IO instance\n%s", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+    defer cancel()
+
+    // A reply that arrives slowly still comes back in one piece.
+    go func() {
+        far.Write([]uint8("SP"))
+        time.Sleep(20 * time.Millisecond)
+        far.Write([]uint8("I1"))
+    }()
+    bytes, err := nbp.ReadExactly(ctx, 4)
+    if err != nil || string(bytes) != MODE_SPI_REPLY {
+        t.Fatalf("ReadExactly got %q, %s", bytes, err)
+    }
+
+    go far.Write([]uint8("#\r\nRESET\r\nHiZ>"))
+    bytes, err = nbp.ReadUntil(ctx, nbp.isPrompt)
+    if err != nil || string(bytes) != "#\r\nRESET\r\nHiZ>" {
+        t.Fatalf("ReadUntil got %q, %s", bytes, err)
+    }
+
+    short, short_cancel := context.WithTimeout(ctx, 10 * time.Millisecond)
+    defer short_cancel()
+    if _, err := nbp.ReadExactly(short, 1); !errors.Is(err, ErrTimeout) {
+        t.Fatalf("Expected ErrTimeout, got: %v", err)
+    }
+} //TestReadExactly()
+
+// newEmulatedBP returns an initialized BP talking to a fresh Emulator, closed
+// when the test is done.
+func newEmulatedBP(t *testing.T) (*BP, *Emulator) {
+
+    em := NewEmulator()
+    nbp := NewBPWithTransport(em.Transport())
+
+    if err := nbp.Init(); err != nil {
+        t.Fatalf("Unable to initialize a Buspirate IO instance\n%s", err)
+    }
+    t.Cleanup(func() { nbp.Close() })
+
+    return nbp, em
+} //newEmulatedBP()
+
+func TestEmulatorReset (t *testing.T) {
+
+    nbp, em := newEmulatedBP(t)
+
+    if err := nbp.Reset(); err != nil {
+        t.Fatalf("Reset failed: %s", err)
+    }
+    if em.Mode() != EMU_TERMINAL {
+        t.Fatalf("Expected the terminal after Reset, emulator in mode %d", em.Mode())
+    }
+    if nbp.ReadTimeout != DEFAULT_TIMEOUT * time.Millisecond {
+        t.Fatalf("Reset clobbered ReadTimeout: %s", nbp.ReadTimeout)
+    }
+} //TestEmulatorReset()
+
+// A 4096 byte reply takes ~350ms at 115200 baud, far longer than ReadTimeout.
+func TestReadContextScales (t *testing.T) {
+
+    nbp, _ := newEmulatedBP(t)
+
+    ctx, cancel := nbp.readContext(4096)
+    defer cancel()
+
+    deadline, ok := ctx.Deadline()
+    if !ok || time.Until(deadline) < nbp.ReadTimeout + 4096 * 87 * time.Microsecond {
+        t.Fatalf("Deadline too short for 4096 bytes: %s", time.Until(deadline))
+    }
+} //TestReadContextScales()
+
+func TestEmulatorBinaryMode (t *testing.T) {
+
+    nbp, em := newEmulatedBP(t)
+
+    if err := nbp.BinaryMode(); err != nil {
+        t.Fatalf("BinaryMode failed: %s", err)
+    }
+    if em.Mode() != EMU_BITBANG {
+        t.Fatalf("Expected bitbang mode, emulator in mode %d", em.Mode())
+    }
+
+    // Already in binary mode, this should not go through a reset.
+    if err := nbp.BinaryMode(); err != nil {
+        t.Fatalf("BinaryMode failed: %s", err)
+    }
+
+    if err := nbp.HWReset(); err != nil {
+        t.Fatalf("HWReset failed: %s", err)
+    }
+    if em.Mode() != EMU_TERMINAL {
+        t.Fatalf("Expected the terminal after HWReset, emulator in mode %d", em.Mode())
+    }
+} //TestEmulatorBinaryMode()
+
+func TestEmulatorSelfTest (t *testing.T) {
+
+    nbp, em := newEmulatedBP(t)
+    em.SetADC(0x200)
+
+    if err := nbp.BinaryMode(); err != nil {
+        t.Fatalf("BinaryMode failed: %s", err)
+    }
+
+    bytes, err := nbp.WriteRead([]uint8{VOLT_MEASURE})
+    if err != nil || len(bytes) != 2 || bytes[0] != 0x02 || bytes[1] != 0x00 {
+        t.Fatalf("VOLT_MEASURE got %q, %s", bytes, err)
+    }
+
+    if err := nbp.ShortTest(); err != nil {
+        t.Fatalf("ShortTest failed: %s", err)
+    }
+    if em.Mode() != EMU_BITBANG {
+        t.Fatalf("Expected bitbang mode after the self-test, emulator in mode %d", em.Mode())
+    }
+
+    em.SetSelfTestErrors(3)
+    if err := nbp.LongTest(); err == nil {
+        t.Fatalf("Expected LongTest to fail with 3 errors")
+    }
+    if em.Mode() != EMU_BITBANG {
+        t.Fatalf("Expected bitbang mode after the self-test, emulator in mode %d", em.Mode())
+    }
+} //TestEmulatorSelfTest()
+
+func TestEmulatorPins (t *testing.T) {
+
+    nbp, em := newEmulatedBP(t)
+    em.SetInputPins(0x02)
+
+    if err := nbp.BinaryMode(); err != nil {
+        t.Fatalf("BinaryMode failed: %s", err)
+    }
+
+    // Every pin starts as an input, only MISO is high.
+    state, err := nbp.ReadPins()
+    if err != nil || state != PinState(PIN_MISO) {
+        t.Fatalf("Expected only MISO high, got %s, %v", state, err)
+    }
+
+    // MISO as an input, CLK an output driven high.
+    if _, err := nbp.SetPinsOut(PINS_IO &^ PIN_MISO); err != nil {
+        t.Fatalf("SetPinsOut failed: %s", err)
+    }
+    state, err = nbp.SetPinsHigh(PIN_CLK | PIN_POWER)
+    if err != nil || !state.High(PIN_CLK | PIN_POWER | PIN_MISO) || state.High(PIN_AUX) {
+        t.Fatalf("Expected POWER, CLK and MISO high, got %s, %v", state, err)
+    }
+    if _, high_low := em.Pins(); high_low != uint8(PIN_CLK | PIN_POWER) {
+        t.Fatalf("Expected CLK and POWER set, got %X", high_low)
+    }
+
+    state, err = nbp.SetPinsLow(PIN_POWER)
+    if err != nil || state != PinState(PIN_CLK | PIN_MISO) {
+        t.Fatalf("Expected CLK and MISO high, got %s, %v", state, err)
+    }
+    if state.String() != "POWER:0 PULLUP:0 AUX:0 MOSI:0 CLK:1 MISO:1 CS:0" {
+        t.Fatalf("Unexpected state string: %s", state)
+    }
+
+    // The input follows the line, the output is still driven high.
+    em.SetInputPins(0x00)
+    state, err = nbp.ReadPins()
+    if err != nil || state != PinState(PIN_CLK) {
+        t.Fatalf("Expected only CLK high, got %s, %v", state, err)
+    }
+    _, err = nbp.SetPinsIn(PIN_CLK)
+    if in_out, _ := em.Pins(); err != nil || in_out != uint8(PIN_CLK | PIN_MISO) {
+        t.Fatalf("SetPinsIn failed: %v, %X", err, in_out)
+    }
+
+    if _, err := nbp.SetPinsIn(PIN_POWER); err == nil {
+        t.Fatalf("Expected an error making POWER an input")
+    }
+    if _, err := nbp.ModeI2C(); err != nil {
+        t.Fatalf("ModeI2C failed: %s", err)
+    }
+    if _, err := nbp.SetPinsHigh(PIN_AUX); err != ErrNotInMode {
+        t.Fatalf("Expected ErrNotInMode, got: %v", err)
+    }
+} //TestEmulatorPins()
+
+// The reply to SET_PINS_IN_OUT carries its 010 prefix where POWER and PULLUP
+// would be, they have to come from what was last set.
+func TestEmulatorPinsInOutReply (t *testing.T) {
+
+    nbp, em := newEmulatedBP(t)
+    em.SetInputPins(0x10)
+
+    if err := nbp.BinaryMode(); err != nil {
+        t.Fatalf("BinaryMode failed: %s", err)
+    }
+
+    state, err := nbp.ReadPins()
+    if err != nil || state != PinState(PIN_AUX) {
+        t.Fatalf("Expected only AUX high, got %s, %v", state, err)
+    }
+
+    if _, err := nbp.SetPinsHigh(PIN_PULLUP | PIN_CS); err != nil {
+        t.Fatalf("SetPinsHigh failed: %s", err)
+    }
+    state, err = nbp.SetPinsOut(PIN_CS)
+    if err != nil || state != PinState(PIN_PULLUP | PIN_AUX | PIN_CS) {
+        t.Fatalf("Expected PULLUP, AUX and CS high, got %s, %v", state, err)
+    }
+    if in_out, _ := em.Pins(); in_out != uint8(PINS_IO &^ PIN_CS) {
+        t.Fatalf("Expected CS an output, got %X", in_out)
+    }
+
+    if _, err := nbp.SetPinsLow(PIN_PULLUP | PIN_CS); err != nil {
+        t.Fatalf("SetPinsLow failed: %s", err)
+    }
+    state, err = nbp.ReadPins()
+    if err != nil || state != PinState(PIN_AUX) {
+        t.Fatalf("Expected only AUX high, got %s, %v", state, err)
+    }
+} //TestEmulatorPinsInOutReply()
+
+func TestPWM (t *testing.T) {
+
+    nbp, em := newEmulatedBP(t)
+
+    if err := nbp.BinaryMode(); err != nil {
+        t.Fatalf("BinaryMode failed: %s", err)
+    }
+
+    freq, duty, err := nbp.SetPWM(1000, 50)
+    if err != nil || freq != 1000 || duty != 50 {
+        t.Fatalf("SetPWM expected 1000Hz 50%%, got %v %v, %v", freq, duty, err)
+    }
+    // 1:1, duty 8000, period 15999
+    if pwm := em.PWM(); string(pwm) != "\x00\x1F\x40\x3E\x7F" {
+        t.Fatalf("Unexpected SET_PWM bytes: %X", pwm)
+    }
+
+    // Needs the 1:64 prescaler, and 3kHz isn't exact.
+    _, _, err = nbp.SetPWM(10, 25)
+    if pwm := em.PWM(); err != nil || len(pwm) == 0 || pwm[0] != 0x02 {
+        t.Fatalf("Expected prescaler 1:64, got %X, %v", pwm, err)
+    }
+    freq, _, err = nbp.SetPWM(3000, 10)
+    if err != nil || freq == 3000 || freq < 2999 || freq > 3001 {
+        t.Fatalf("Expected about 3kHz, got %v, %v", freq, err)
+    }
+    if _, _, err := nbp.SetPWM(0.1, 50); err == nil {
+        t.Fatalf("Expected an error for 0.1Hz")
+    }
+
+    // The lowest frequency, 1:256 and a period of 0xFFFE, still fits a
+    // 100% duty cycle.
+    freq, duty, err = nbp.SetPWM(PWM_FCY / (256.0 * 0xFFFF), 100)
+    if pwm := em.PWM(); err != nil || duty != 100 || string(pwm) != "\x03\xFF\xFF\xFF\xFE" {
+        t.Fatalf("Expected 100%% at the lowest frequency, got %v%% %X, %v", duty, pwm, err)
+    }
+    if _, _, err := nbp.SetPWM(PWM_FCY / (256.0 * 0x10000), 100); err == nil {
+        t.Fatalf("Expected an error for a period of 0xFFFF")
+    }
+
+    err = nbp.ClearPWM()
+    if pwm := em.PWM(); err != nil || pwm != nil {
+        t.Fatalf("ClearPWM failed: %v, %X", err, pwm)
+    }
+
+    if _, err := nbp.ModeI2C(); err != nil {
+        t.Fatalf("ModeI2C failed: %s", err)
+    }
+    if _, _, err := nbp.SetPWM(1000, 50); err != ErrNotInMode {
+        t.Fatalf("Expected ErrNotInMode, got: %v", err)
+    }
+} //TestPWM()
+
+func TestVoltage (t *testing.T) {
+
+    nbp, em := newEmulatedBP(t)
+    em.SetADC(0x200)
+
+    if err := nbp.BinaryMode(); err != nil {
+        t.Fatalf("BinaryMode failed: %s", err)
+    }
+
+    // Half scale, 3.3V
+    if v, err := nbp.MeasureVoltage(); err != nil || v != 3.3 {
+        t.Fatalf("MeasureVoltage expected 3.3, got %v, %v", v, err)
+    }
+
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    samples, err := nbp.StreamVoltage(ctx)
+    if err != nil {
+        t.Fatalf("StreamVoltage failed: %s", err)
+    }
+    // There's no reply to wait for.
+    for i := 0; i < 100 && em.Mode() != EMU_VOLT_STREAM; i++ {
+        time.Sleep(time.Millisecond)
+    }
+    if em.Mode() != EMU_VOLT_STREAM {
+        t.Fatalf("Expected streaming, emulator in mode %d", em.Mode())
+    }
+
+    em.SetADC(0x100)
+    em.StreamADC(3)
+    for i := 0; i < 3; i++ {
+        s := <-samples
+        if s.Volts != 1.65 || s.Time.IsZero() {
+            t.Fatalf("Expected 1.65V, got %+v", s)
+        }
+    }
+
+    cancel()
+    for range samples {
+    }
+    if em.Mode() != EMU_BITBANG {
+        t.Fatalf("Expected bitbang mode, emulator in mode %d", em.Mode())
+    }
+    if _, err := nbp.MeasureVoltage(); err != nil {
+        t.Fatalf("MeasureVoltage after streaming failed: %s", err)
+    }
+} //TestVoltage()
+
+func TestFrequency (t *testing.T) {
+
+    nbp, em := newEmulatedBP(t)
+    em.SetFrequency(16000000)
+
+    if err := nbp.BinaryMode(); err != nil {
+        t.Fatalf("BinaryMode failed: %s", err)
+    }
+
+    if hz, err := nbp.MeasureFrequency(); err != nil || hz != 16000000 {
+        t.Fatalf("MeasureFrequency expected 16MHz, got %v, %v", hz, err)
+    }
+
+    em.SetFrequency(999, 1001, 1000, 1004)
+    stats, err := nbp.MeasureFrequencyN(4, time.Millisecond)
+    if err != nil || len(stats.Samples) != 4 {
+        t.Fatalf("MeasureFrequencyN failed: %+v, %v", stats, err)
+    }
+    if stats.Min != 999 || stats.Max != 1004 || stats.Mean != 1001 {
+        t.Fatalf("Unexpected stats: %+v", stats)
+    }
+
+    if _, err := nbp.ModeSPI(); err != nil {
+        t.Fatalf("ModeSPI failed: %s", err)
+    }
+    if _, err := nbp.MeasureFrequency(); err != ErrNotInMode {
+        t.Fatalf("Expected ErrNotInMode, got: %v", err)
+    }
+} //TestFrequency()
+
+func TestEmulatorModeI2C (t *testing.T) {
+
+    nbp, em := newEmulatedBP(t)
+
+    i2c, err := nbp.ModeI2C()
+    if err != nil || i2c == nil {
+        t.Fatalf("ModeI2C failed: %s", err)
+    }
+    if em.Mode() != EMU_I2C {
+        t.Fatalf("Expected I2C mode, emulator in mode %d", em.Mode())
+    }
+
+    mode, err := nbp.GetMode()
+    if err != nil || mode != MODE_I2C_REPLY {
+        t.Fatalf("Expected mode %q, got %q, %s", MODE_I2C_REPLY, mode, err)
+    }
+} //TestEmulatorModeI2C()
+
+func TestErrors (t *testing.T) {
+
+    // Nothing on the far end of the pipe ever answers.
+    near, _ := NewPipe()
+    nbp := NewBPWithTransport(near)
+    nbp.ReadTimeout = 10 * time.Millisecond
+    if err := nbp.Init(); err != nil {
+        t.Fatalf("Unable to initialize a Buspirate IO instance\n%s", err)
+    }
+    if _, err := nbp.writeReadN([]uint8{GET_MODE}, 4); !errors.Is(err, ErrTimeout) {
+        t.Fatalf("Expected ErrTimeout, got: %v", err)
+    }
+
+    // Going through SPI mode leaves an old I2C unusable.
+    nbp, _ = newEmulatedBP(t)
+    i2c, err := nbp.ModeI2C()
+    if err != nil {
+        t.Fatalf("ModeI2C failed: %s", err)
+    }
+    if _, err := nbp.ModeSPI(); err != nil {
+        t.Fatalf("ModeSPI failed: %s", err)
+    }
+    if _, err := i2c.Start(); !errors.Is(err, ErrNotInMode) {
+        t.Fatalf("Expected ErrNotInMode, got: %v", err)
+    }
+
+    // Entering I2C isn't answered with SPI1.
+    if err := nbp.BinaryMode(); err != nil {
+        t.Fatalf("BinaryMode failed: %s", err)
+    }
+    _, err = nbp.writeFind([]uint8{MODE_I2C}, MODE_SPI_REPLY)
+    var reply *ErrUnexpectedReply
+    if !errors.As(err, &reply) || string(reply.Got) != MODE_I2C_REPLY {
+        t.Fatalf("Expected ErrUnexpectedReply, got: %v", err)
+    }
+} //TestErrors()
+
+func TestLockNested (t *testing.T) {
+
+    nbp, _ := newEmulatedBP(t)
+
+    outer := nbp.Lock()
+    inner := outer.Lock()
+    if inner != outer {
+        t.Fatalf("Expected Lock on a held handle to return it")
+    }
+    inner.Unlock()
+
+    // Still held by outer, a Lock elsewhere has to wait.
+    other := make(chan *BP)
+    go func() {
+        other <- nbp.Lock()
+    }()
+    select {
+        case <-other:
+            t.Fatalf("Locked while the outer Lock was held")
+        case <-time.After(20 * time.Millisecond):
+    }
+
+    outer.Unlock()
+    tx := <-other
+
+    // inner is released along with outer, so it waits for tx too.
+    done := make(chan error)
+    go func() {
+        done <- inner.Transaction(func(*BP) error { return nil })
+    }()
+    select {
+        case <-done:
+            t.Fatalf("Stale inner handle used the BP while another held it")
+        case <-time.After(20 * time.Millisecond):
+    }
+
+    tx.Unlock()
+    if err := <-done; err != nil {
+        t.Fatalf("Transaction failed: %s", err)
+    }
+} //TestLockNested()
+
+func TestClose (t *testing.T) {
+
+    nbp, em := newEmulatedBP(t)
+
+    i2c, err := nbp.ModeI2C()
+    if err != nil {
+        t.Fatalf("ModeI2C failed: %s", err)
+    }
+    if err := nbp.modeWriteOK(MODE_I2C, []uint8{SET_PERIPH | PERIPH_POWER}); err != nil {
+        t.Fatalf("Power on failed: %s", err)
+    }
+
+    if err := nbp.Close(); err != nil {
+        t.Fatalf("Close failed: %s", err)
+    }
+    if em.Mode() != EMU_TERMINAL {
+        t.Fatalf("Expected the terminal after Close, emulator in mode %d", em.Mode())
+    }
+    if periph := em.I2CPeripherals(); periph != 0 {
+        t.Fatalf("Expected the power off after Close, peripherals: %X", periph)
+    }
+
+    select {
+        case <-nbp.reader_done:
+        default:
+            t.Fatalf("Reader still running after Close")
+    }
+
+    if _, err := i2c.Start(); !errors.Is(err, ErrClosed) {
+        t.Fatalf("Expected ErrClosed, got: %v", err)
+    }
+    if _, err := nbp.WriteRead([]uint8{0x0D}); !errors.Is(err, ErrClosed) {
+        t.Fatalf("Expected ErrClosed, got: %v", err)
+    }
+    if err := nbp.Close(); !errors.Is(err, ErrClosed) {
+        t.Fatalf("Expected ErrClosed from a second Close, got: %v", err)
+    }
+} //TestClose()
@@ -0,0 +1,179 @@
+package buspirate
+
+import (
+    "errors"
+    "fmt"
+    "time"
+)
+
+const (
+    DS18B20_FAMILY = 0x28
+    DS18B20_CONVERT_T = 0x44
+    DS18B20_WRITE_SCRATCHPAD = 0x4E
+    DS18B20_READ_SCRATCHPAD = 0xBE
+    DS18B20_COPY_SCRATCHPAD = 0x48
+    DS18B20_SCRATCHPAD_SIZE = 9
+    // Scratchpad layout
+    DS18B20_TEMP_LSB = 0
+    DS18B20_TEMP_MSB = 1
+    DS18B20_TH = 2
+    DS18B20_TL = 3
+    DS18B20_CONFIG = 4
+    DS18B20_CRC = 8
+    // Maximum conversion time at 12-bit resolution, halved per bit less.
+    DS18B20_CONVERT_TIME = 750 * time.Millisecond
+)
+
+// DS18B20 is a Maxim DS18B20 temperature sensor on a 1-Wire bus.
+type DS18B20 struct {
+    Ow *OneWire
+    // ROM addresses the sensor, 0 uses SKIP ROM for a single sensor bus.
+    ROM ROMCode
+    // ConvertTime is how long Temperature waits for a conversion.
+    ConvertTime time.Duration
+}
+
+func NewDS18B20(ow *OneWire, rom ROMCode) *DS18B20 {
+    return &DS18B20{Ow: ow, ROM: rom, ConvertTime: DS18B20_CONVERT_TIME}
+} //NewDS18B20()
+
+// FindDS18B20 searches the bus and returns every DS18B20 on it.
+func FindDS18B20(ow *OneWire) ([]*DS18B20, error) {
+
+    roms, err := ow.Search()
+    if err != nil {
+        return nil, err
+    }
+
+    var res []*DS18B20
+    for _, rom := range roms {
+        if rom.Family() == DS18B20_FAMILY {
+            res = append(res, NewDS18B20(ow, rom))
+        }
+    }
+
+    return res, nil
+} //FindDS18B20()
+
+// ConvertAll starts a temperature conversion on every sensor on the bus at
+// once. Wait DS18B20_CONVERT_TIME before reading them.
+func ConvertAll(ow *OneWire) error {
+
+    return ow.Transaction(func(ow *OneWire) error {
+        err := ow.Select(0)
+        if err != nil {
+            return err
+        }
+
+        return ow.WriteByte(DS18B20_CONVERT_T)
+    })
+} //ConvertAll()
+
+// Convert starts a temperature conversion on this sensor.
+func (ds *DS18B20) Convert() error {
+
+    return ds.Ow.Transaction(func(ow *OneWire) error {
+        err := ow.Select(ds.ROM)
+        if err != nil {
+            return err
+        }
+
+        return ow.WriteByte(DS18B20_CONVERT_T)
+    })
+} //Convert()
+
+// ReadScratchpad reads and CRC checks the 9 byte scratchpad.
+func (ds *DS18B20) ReadScratchpad() ([]uint8, error) {
+
+    var sp []uint8
+    err := ds.Ow.Transaction(func(ow *OneWire) error {
+        var err error
+        sp, err = ds.readScratchpad(ow)
+        return err
+    })
+
+    return sp, err
+} //ReadScratchpad()
+
+// readScratchpad is ReadScratchpad on an ow that already holds the BP.
+func (ds *DS18B20) readScratchpad(ow *OneWire) ([]uint8, error) {
+
+    err := ow.Select(ds.ROM)
+    if err != nil {
+        return nil, err
+    }
+
+    err = ow.WriteByte(DS18B20_READ_SCRATCHPAD)
+    if err != nil {
+        return nil, err
+    }
+
+    sp, err := ow.ReadBytes(DS18B20_SCRATCHPAD_SIZE)
+    if err != nil {
+        return sp, err
+    }
+
+    if OneWireCRC8(sp[:DS18B20_CRC]) != sp[DS18B20_CRC] {
+        return sp, errors.New(fmt.Sprintf("DS18B20 %s scratchpad CRC error: %X", ds.ROM, sp))
+    }
+
+    return sp, nil
+} //readScratchpad()
+
+// ReadTemperature returns the result of the last conversion in degrees
+// Celsius.
+func (ds *DS18B20) ReadTemperature() (float64, error) {
+
+    sp, err := ds.ReadScratchpad()
+    if err != nil {
+        return 0, err
+    }
+
+    raw := int16(uint16(sp[DS18B20_TEMP_MSB]) << 8 | uint16(sp[DS18B20_TEMP_LSB]))
+    return float64(raw) / 16, nil
+} //ReadTemperature()
+
+// Temperature runs a conversion, waits for it and returns the result in
+// degrees Celsius.
+func (ds *DS18B20) Temperature() (float64, error) {
+
+    err := ds.Convert()
+    if err != nil {
+        return 0, err
+    }
+
+    time.Sleep(ds.ConvertTime)
+    return ds.ReadTemperature()
+} //Temperature()
+
+// SetResolution sets the conversion resolution, 9 to 12 bits, and adjusts
+// ConvertTime to match. The alarm thresholds are left as they were.
+func (ds *DS18B20) SetResolution(bits int) error {
+
+    if bits < 9 || bits > 12 {
+        return errors.New(fmt.Sprintf("Invalid DS18B20 resolution: %d", bits))
+    }
+
+    // Keep TH and TL, read and write back in one go.
+    err := ds.Ow.Transaction(func(ow *OneWire) error {
+        sp, err := ds.readScratchpad(ow)
+        if err != nil {
+            return err
+        }
+
+        err = ow.Select(ds.ROM)
+        if err != nil {
+            return err
+        }
+
+        config := uint8(bits - 9) << 5 | 0x1F
+        return ow.WriteBytes([]uint8{DS18B20_WRITE_SCRATCHPAD,
+            sp[DS18B20_TH], sp[DS18B20_TL], config})
+    })
+    if err != nil {
+        return err
+    }
+
+    ds.ConvertTime = DS18B20_CONVERT_TIME >> uint(12 - bits)
+    return nil
+} //SetResolution()
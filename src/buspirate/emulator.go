@@ -0,0 +1,478 @@
+package buspirate
+
+import (
+    "bufio"
+    "io"
+    "sync"
+)
+
+const (
+    // Emulator modes
+    EMU_TERMINAL = 0x00
+    EMU_BITBANG = 0x01
+    EMU_SELF_TEST = 0x02
+    EMU_I2C = 0x03
+    EMU_SPI = 0x04
+    EMU_UART = 0x05
+    EMU_UART_BRIDGE = 0x06
+    EMU_ONEWIRE = 0x07
+    EMU_RAW = 0x08
+    EMU_I2C_SNIFF = 0x09
+    EMU_VOLT_STREAM = 0x0A
+
+    // Number of 0x00 bytes the terminal needs before it enters bitbang mode.
+    EMU_BB_ZEROS = 20
+
+    EMU_BANNER = "\r\nBus Pirate v3.5\r\n" +
+        "Firmware v5.10 (r559)  Bootloader v4.4\r\n" +
+        "DEVID:0x0447 REVID:0x3046 (24FJ64GA002 B8)\r\n" +
+        "http://dangerousprototypes.com\r\n" + "HiZ>"
+)
+
+// Emulator is an in-process Bus Pirate. It speaks the terminal prompt and the
+// binary (BBIO1) protocol well enough to drive a BP without hardware:
+//
+//     em := NewEmulator()
+//     bp := NewBPWithTransport(em.Transport())
+//     bp.Init()
+//
+type Emulator struct {
+    mu sync.Mutex
+    wmu sync.Mutex
+    rd *bufio.Reader
+    wr io.Writer
+
+    mode uint8
+    zeros int
+
+    adc uint16
+    freq []uint32
+    self_test_errors uint8
+    pins_in_out uint8
+    pins_high_low uint8
+    pins_input uint8
+    // The prescaler, duty cycle and period bytes of the last SET_PWM, nil
+    // while PWM is off.
+    pwm []uint8
+    i2c_periph uint8
+    i2c_speed uint8
+    i2c_bus *SimI2CBus
+    spi_periph uint8
+    spi_speed uint8
+    spi_config uint8
+    spi_dev SimSPIDevice
+    uart_periph uint8
+    uart_baud uint8
+    uart_brg uint16
+    uart_config uint8
+    uart_echo bool
+    uart_tx []uint8
+    ow_periph uint8
+    ow_devs []SimOneWireDevice
+    ow_selected []SimOneWireDevice
+    ow_state uint8
+    ow_match []uint8
+    ow_rom []uint8
+    raw_periph uint8
+    raw_speed uint8
+    raw_config uint8
+    raw_data uint8
+    raw_in []uint8
+    raw_out []uint8
+}
+
+func NewEmulator() *Emulator {
+    return &Emulator{mode: EMU_TERMINAL, pins_in_out: 0x1F,
+        i2c_bus: NewSimI2CBus()}
+} //NewEmulator()
+
+// Transport starts the emulator on one end of a new in-memory pipe and
+// returns the other end for a BP to use. The emulator stops when the
+// returned Transport is closed.
+func (em *Emulator) Transport() Transport {
+    host, dev := NewPipe()
+    go em.Serve(dev)
+    return host
+} //Transport()
+
+// I2CBus returns the simulated bus driven by the emulator's I2C mode.
+func (em *Emulator) I2CBus() *SimI2CBus {
+    return em.i2c_bus
+} //I2CBus()
+
+// AttachI2C puts dev on the emulator's I2C bus.
+func (em *Emulator) AttachI2C(dev SimI2CDevice) {
+    em.i2c_bus.Attach(dev)
+} //AttachI2C()
+
+// AttachSPI connects dev to the emulator's SPI pins, replacing any device
+// already attached.
+func (em *Emulator) AttachSPI(dev SimSPIDevice) {
+    em.mu.Lock()
+    em.spi_dev = dev
+    em.mu.Unlock()
+} //AttachSPI()
+
+// SetADC sets the raw 10-bit ADC value returned for VOLT_MEASURE.
+func (em *Emulator) SetADC(val uint16) {
+    em.mu.Lock()
+    em.adc = val & 0x03FF
+    em.mu.Unlock()
+} //SetADC()
+
+// SetFrequency sets the frequencies the next FREQ_MEASUREs report, in turn,
+// the last one repeating.
+func (em *Emulator) SetFrequency(hz ...uint32) {
+    em.mu.Lock()
+    em.freq = hz
+    em.mu.Unlock()
+} //SetFrequency()
+
+// StreamADC sends n samples of the current ADC value, as if they had been
+// taken, while the voltage is being streamed.
+func (em *Emulator) StreamADC(n int) {
+    em.mu.Lock()
+    defer em.mu.Unlock()
+
+    if em.mode != EMU_VOLT_STREAM {
+        return
+    }
+    for i := 0; i < n; i++ {
+        em.write(uint8(em.adc >> 8), uint8(em.adc))
+    }
+} //StreamADC()
+
+// SetSelfTestErrors sets the error count the self-tests report.
+func (em *Emulator) SetSelfTestErrors(n uint8) {
+    em.mu.Lock()
+    em.self_test_errors = n
+    em.mu.Unlock()
+} //SetSelfTestErrors()
+
+// SetInputPins sets the level seen on pins configured as inputs, using the
+// same bit layout as SET_PINS_HIGH_LOW.
+func (em *Emulator) SetInputPins(mask uint8) {
+    em.mu.Lock()
+    em.pins_input = mask & 0x7F
+    em.mu.Unlock()
+} //SetInputPins()
+
+// Mode returns the emulator's current mode, one of the EMU_* constants.
+func (em *Emulator) Mode() uint8 {
+    em.mu.Lock()
+    defer em.mu.Unlock()
+    return em.mode
+} //Mode()
+
+// Pins returns the last SET_PINS_IN_OUT and SET_PINS_HIGH_LOW bits.
+func (em *Emulator) Pins() (in_out uint8, high_low uint8) {
+    em.mu.Lock()
+    defer em.mu.Unlock()
+    return em.pins_in_out, em.pins_high_low
+} //Pins()
+
+// PWM returns a copy of the last SET_PWM's bytes, nil while PWM is off.
+func (em *Emulator) PWM() []uint8 {
+    em.mu.Lock()
+    defer em.mu.Unlock()
+    if em.pwm == nil {
+        return nil
+    }
+    return append([]uint8{}, em.pwm...)
+} //PWM()
+
+// I2CPeripherals returns the I2C mode's peripheral bits, PERIPH_*.
+func (em *Emulator) I2CPeripherals() uint8 {
+    em.mu.Lock()
+    defer em.mu.Unlock()
+    return em.i2c_periph
+} //I2CPeripherals()
+
+// I2CSpeed returns the I2C mode's speed, one of the I2C_SPEED_* values.
+func (em *Emulator) I2CSpeed() uint8 {
+    em.mu.Lock()
+    defer em.mu.Unlock()
+    return em.i2c_speed
+} //I2CSpeed()
+
+// Serve runs the emulator on rw until a read from it fails.
+func (em *Emulator) Serve(rw io.ReadWriter) error {
+
+    em.rd = bufio.NewReader(rw)
+    em.wr = rw
+
+    for {
+        b, err := em.rd.ReadByte()
+        if err != nil {
+            return err
+        }
+
+        em.mu.Lock()
+        err = em.handle(b)
+        em.mu.Unlock()
+
+        if err != nil {
+            return err
+        }
+    }
+} //Serve()
+
+func (em *Emulator) write(data ...uint8) {
+    em.wmu.Lock()
+    em.wr.Write(data)
+    em.wmu.Unlock()
+} //write()
+
+func (em *Emulator) writeString(s string) {
+    em.write([]uint8(s)...)
+} //writeString()
+
+// readN reads the argument bytes that follow a multi-byte command.
+func (em *Emulator) readN(n int) ([]uint8, error) {
+    buf := make([]uint8, n)
+    _, err := io.ReadFull(em.rd, buf)
+    return buf, err
+} //readN()
+
+func (em *Emulator) handle(b uint8) error {
+
+    switch em.mode {
+        case EMU_TERMINAL:
+            em.terminal(b)
+        case EMU_BITBANG:
+            return em.bitbang(b)
+        case EMU_SELF_TEST:
+            em.selfTest(b)
+        case EMU_I2C:
+            return em.i2c(b)
+        case EMU_SPI:
+            return em.spi(b)
+        case EMU_UART:
+            return em.uart(b)
+        case EMU_ONEWIRE:
+            return em.oneWire(b)
+        case EMU_RAW:
+            return em.rawWire(b)
+        case EMU_UART_BRIDGE:
+            em.uart_tx = append(em.uart_tx, b)
+        case EMU_I2C_SNIFF:
+            // Any byte ends sniffing, without a reply.
+            em.mode = EMU_I2C
+        case EMU_VOLT_STREAM:
+            em.mode = EMU_BITBANG
+    }
+
+    return nil
+} //handle()
+
+func (em *Emulator) terminal(b uint8) {
+
+    if b == BINARY_RESET {
+        em.zeros++
+        if em.zeros >= EMU_BB_ZEROS {
+            em.zeros = 0
+            em.enterBitbang()
+        }
+        return
+    }
+    em.zeros = 0
+
+    switch b {
+        case '\r':
+            em.writeString(MODE_HIZ_REPLY1)
+        case '#':
+            em.writeString("#\r\nRESET\r\n" + EMU_BANNER)
+        default:
+            // The terminal echoes anything else.
+            em.write(b)
+    }
+} //terminal()
+
+func (em *Emulator) enterBitbang() {
+    em.mode = EMU_BITBANG
+    em.pins_in_out = 0x1F
+    em.pins_high_low = 0
+    em.writeString(MODE_BB_REPLY)
+} //enterBitbang()
+
+// pinState is the byte the bitbang pin commands reply with: outputs read back
+// what was set, inputs read the emulated input levels.
+func (em *Emulator) pinState() uint8 {
+    // POWER and PULLUP are always outputs.
+    out_mask := uint8(0x60) | (^em.pins_in_out & 0x1F)
+    return (em.pins_high_low & out_mask) | (em.pins_input & ^out_mask & 0x7F)
+} //pinState()
+
+func (em *Emulator) bitbang(b uint8) error {
+
+    switch {
+        case b == BINARY_RESET:
+            em.writeString(MODE_BB_REPLY)
+        case b == MODE_SPI:
+            em.mode = EMU_SPI
+            em.spi_config = SPI_CONF_DEFAULT
+            em.writeString(MODE_SPI_REPLY)
+        case b == MODE_I2C:
+            em.mode = EMU_I2C
+            em.i2c_speed = I2C_SPEED_5
+            em.writeString(MODE_I2C_REPLY)
+        case b == MODE_1WIRE:
+            em.mode = EMU_ONEWIRE
+            em.writeString(MODE_1WIRE_REPLY)
+        case b == MODE_RAW:
+            em.mode = EMU_RAW
+            em.raw_config = 0
+            em.writeString(MODE_RAW_REPLY)
+        case b == MODE_UART:
+            em.mode = EMU_UART
+            em.uart_echo = false
+            em.writeString(MODE_UART_REPLY)
+        case b == HW_RESET:
+            em.mode = EMU_TERMINAL
+            em.zeros = 0
+            em.pins_in_out = 0x1F
+            em.pins_high_low = 0
+            em.pwm = nil
+            em.write(HW_RESET_REPLY)
+            em.writeString(EMU_BANNER)
+        case b == HW_TEST_SHORT || b == HW_TEST_LONG:
+            em.mode = EMU_SELF_TEST
+            em.write(em.self_test_errors)
+        case b == VOLT_MEASURE:
+            em.write(uint8(em.adc >> 8), uint8(em.adc))
+        case b == FREQ_MEASURE:
+            var hz uint32
+            if len(em.freq) > 0 {
+                hz = em.freq[0]
+            }
+            if len(em.freq) > 1 {
+                em.freq = em.freq[1:]
+            }
+            em.write(uint8(hz >> 24), uint8(hz >> 16), uint8(hz >> 8), uint8(hz))
+        case b == VOLT_STREAM:
+            em.mode = EMU_VOLT_STREAM
+        case b == SET_PWM:
+            pwm, err := em.readN(5)
+            if err != nil {
+                return err
+            }
+            em.pwm = pwm
+            em.write(0x01)
+        case b == CLEAR_PWM:
+            em.pwm = nil
+            em.write(0x01)
+        case b & 0xE0 == SET_PINS_IN_OUT:
+            em.pins_in_out = b & 0x1F
+            // The reply keeps the command's 010 prefix.
+            em.write(SET_PINS_IN_OUT | em.pinState() & 0x1F)
+        case b & 0x80 == SET_PINS_HIGH_LOW:
+            em.pins_high_low = b & 0x7F
+            em.write(em.pinState())
+        // Anything else is ignored, like the firmware does.
+    }
+
+    return nil
+} //bitbang()
+
+func (em *Emulator) selfTest(b uint8) {
+
+    // The self-test echoes the error count for every byte until 0xFF.
+    if b == SELF_TEST_EXIT {
+        em.mode = EMU_BITBANG
+        em.write(0x01)
+        return
+    }
+
+    em.write(em.self_test_errors)
+} //selfTest()
+
+func (em *Emulator) i2c(b uint8) error {
+
+    switch {
+        case b == BINARY_RESET:
+            em.enterBitbang()
+        case b == GET_MODE:
+            em.writeString(MODE_I2C_REPLY)
+        case b == I2C_SEND_START:
+            em.i2c_bus.Start()
+            em.write(0x01)
+        case b == I2C_SEND_STOP:
+            em.i2c_bus.Stop()
+            em.write(0x01)
+        case b == I2C_SEND_ACK, b == I2C_SEND_NACK:
+            em.write(0x01)
+        case b == I2C_READ_BYTE:
+            em.write(em.i2c_bus.Read())
+        case b == I2C_WRITE_READ:
+            return em.i2cWriteRead()
+        case b == I2C_SNIFFER:
+            em.mode = EMU_I2C_SNIFF
+            em.write(0x01)
+        case b & 0xF0 == I2C_BULK_SEND:
+            data, err := em.readN(int(b & 0x0F) + 1)
+            if err != nil {
+                return err
+            }
+            em.write(0x01)
+            for _, d := range data {
+                // 0x00 is an ACK, 0x01 a NACK
+                if em.i2c_bus.Write(d) {
+                    em.write(0x00)
+                } else {
+                    em.write(0x01)
+                }
+            }
+        case b & 0xF0 == SET_PINS_IN_OUT:
+            em.i2c_periph = b & 0x0F
+            em.write(0x01)
+        case b & 0xFC == I2C_SET_SPEED:
+            em.i2c_speed = b & 0x03
+            em.write(0x01)
+    }
+
+    return nil
+} //i2c()
+
+// I2CSniffSend hands the host raw sniffer output, as if the sniffer had
+// seen traffic from another master. It is dropped unless sniffing.
+func (em *Emulator) I2CSniffSend(raw []uint8) {
+    em.mu.Lock()
+    defer em.mu.Unlock()
+
+    if em.mode == EMU_I2C_SNIFF {
+        em.write(raw...)
+    }
+} //I2CSniffSend()
+
+func (em *Emulator) i2cWriteRead() error {
+
+    counts, err := em.readN(4)
+    if err != nil {
+        return err
+    }
+    nw := int(counts[0]) << 8 | int(counts[1])
+    nr := int(counts[2]) << 8 | int(counts[3])
+
+    w, err := em.readN(nw)
+    if err != nil {
+        return err
+    }
+
+    em.i2c_bus.Start()
+    for _, d := range w {
+        if !em.i2c_bus.Write(d) {
+            em.i2c_bus.Stop()
+            em.write(0x00)
+            return nil
+        }
+    }
+
+    res := []uint8{0x01}
+    for i := 0; i < nr; i++ {
+        res = append(res, em.i2c_bus.Read())
+    }
+    em.i2c_bus.Stop()
+    em.write(res...)
+
+    return nil
+} //i2cWriteRead()
@@ -0,0 +1,118 @@
+package buspirate
+
+const (
+    // Where the emulated 1-Wire bus is after a reset
+    EMU_OW_ROM_CMD = 0x00
+    EMU_OW_MATCH = 0x01
+    EMU_OW_FUNCTION = 0x02
+)
+
+// AttachOneWire puts dev on the emulator's 1-Wire bus.
+func (em *Emulator) AttachOneWire(dev SimOneWireDevice) {
+    em.mu.Lock()
+    em.ow_devs = append(em.ow_devs, dev)
+    em.mu.Unlock()
+} //AttachOneWire()
+
+func (em *Emulator) owReset() {
+
+    for _, dev := range em.ow_devs {
+        dev.OnReset()
+    }
+    em.ow_selected = nil
+    em.ow_state = EMU_OW_ROM_CMD
+    em.ow_match = nil
+    em.ow_rom = nil
+} //owReset()
+
+// owWrite runs a written byte through the ROM command layer, then hands it
+// to the selected devices.
+func (em *Emulator) owWrite(b uint8) {
+
+    switch em.ow_state {
+        case EMU_OW_ROM_CMD:
+            em.ow_state = EMU_OW_FUNCTION
+            switch b {
+                case ONEWIRE_CMD_SKIP_ROM:
+                    em.ow_selected = em.ow_devs
+                case ONEWIRE_CMD_MATCH_ROM:
+                    em.ow_state = EMU_OW_MATCH
+                case ONEWIRE_CMD_READ_ROM:
+                    em.ow_selected = em.ow_devs
+                    if len(em.ow_devs) == 1 {
+                        em.ow_rom = em.ow_devs[0].ROM().Bytes()
+                    }
+            }
+        case EMU_OW_MATCH:
+            em.ow_match = append(em.ow_match, b)
+            if len(em.ow_match) == 8 {
+                em.ow_state = EMU_OW_FUNCTION
+                for _, dev := range em.ow_devs {
+                    if string(dev.ROM().Bytes()) == string(em.ow_match) {
+                        em.ow_selected = []SimOneWireDevice{dev}
+                    }
+                }
+            }
+        case EMU_OW_FUNCTION:
+            for _, dev := range em.ow_selected {
+                dev.OnWrite(b)
+            }
+    }
+} //owWrite()
+
+func (em *Emulator) owRead() uint8 {
+
+    if len(em.ow_rom) > 0 {
+        b := em.ow_rom[0]
+        em.ow_rom = em.ow_rom[1:]
+        return b
+    }
+
+    // Open drain bus, every selected device can pull a bit low.
+    res := uint8(0xFF)
+    for _, dev := range em.ow_selected {
+        res &= dev.OnRead()
+    }
+
+    return res
+} //owRead()
+
+func (em *Emulator) oneWire(b uint8) error {
+
+    switch {
+        case b == BINARY_RESET:
+            em.enterBitbang()
+        case b == GET_MODE:
+            em.writeString(MODE_1WIRE_REPLY)
+        case b == ONEWIRE_RESET:
+            // Always 0x01, presence pulse or not.
+            em.owReset()
+            em.write(0x01)
+        case b == ONEWIRE_READ_BYTE:
+            em.write(em.owRead())
+        case b == ONEWIRE_ROM_SEARCH, b == ONEWIRE_ALARM_SEARCH:
+            em.owReset()
+            res := []uint8{0x01}
+            for _, rom := range simOneWireSearch(em.ow_devs, b == ONEWIRE_ALARM_SEARCH) {
+                res = append(res, rom.Bytes()...)
+            }
+            res = append(res, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF)
+            em.write(res...)
+        case b & 0xF0 == ONEWIRE_BULK_WRITE:
+            data, err := em.readN(int(b & 0x0F) + 1)
+            if err != nil {
+                return err
+            }
+            res := []uint8{0x01}
+            for _, d := range data {
+                em.owWrite(d)
+                res = append(res, 0x01)
+            }
+            em.write(res...)
+        case b & 0xF0 == SET_PERIPH:
+            em.ow_periph = b & 0x0F
+            em.write(0x01)
+    }
+
+    return nil
+} //oneWire()
@@ -0,0 +1,147 @@
+package buspirate
+
+// RawWireSend queues bits, MSB first from each byte of data, for the
+// emulated target to drive onto the raw-wire input pin. With nothing queued
+// the input reads 1.
+func (em *Emulator) RawWireSend(data []uint8) {
+    em.mu.Lock()
+    defer em.mu.Unlock()
+
+    for _, b := range data {
+        for i := 7; i >= 0; i-- {
+            em.raw_in = append(em.raw_in, (b >> uint(i)) & 0x01)
+        }
+    }
+} //RawWireSend()
+
+// RawWireReceived returns, and clears, the bits the Bus Pirate has clocked
+// out in raw-wire mode, one 0 or 1 per byte, in wire order.
+func (em *Emulator) RawWireReceived() []uint8 {
+    em.mu.Lock()
+    defer em.mu.Unlock()
+
+    res := em.raw_out
+    em.raw_out = nil
+    return res
+} //RawWireReceived()
+
+func (em *Emulator) rawPeek() uint8 {
+    if len(em.raw_in) == 0 {
+        return 1
+    }
+    return em.raw_in[0]
+} //rawPeek()
+
+// rawTick clocks the current data level out and the next input bit in.
+func (em *Emulator) rawTick() uint8 {
+
+    em.raw_out = append(em.raw_out, em.raw_data)
+
+    bit := em.rawPeek()
+    if len(em.raw_in) > 0 {
+        em.raw_in = em.raw_in[1:]
+    }
+
+    // 2-wire mode reads back the shared data line.
+    if em.raw_config & RAW_CONF_3WIRE == 0 {
+        return em.raw_data
+    }
+    return bit
+} //rawTick()
+
+// rawByte clocks n bits of b out, in the configured bit order, and returns
+// the bits clocked in.
+func (em *Emulator) rawByte(b uint8, n int) uint8 {
+
+    var res uint8
+    for i := 0; i < n; i++ {
+        if em.raw_config & RAW_CONF_LSB != 0 {
+            em.raw_data = (b >> uint(i)) & 0x01
+            res |= em.rawTick() << uint(i)
+        } else {
+            em.raw_data = (b >> uint(7 - i)) & 0x01
+            res = res << 1 | em.rawTick()
+        }
+    }
+
+    return res
+} //rawByte()
+
+// rawReadByte clocks a byte in with the data line released.
+func (em *Emulator) rawReadByte() uint8 {
+
+    var res uint8
+    em.raw_data = 1
+    for i := 0; i < 8; i++ {
+        bit := em.rawPeek()
+        em.rawTick()
+        if em.raw_config & RAW_CONF_LSB != 0 {
+            res |= bit << uint(i)
+        } else {
+            res = res << 1 | bit
+        }
+    }
+
+    return res
+} //rawReadByte()
+
+func (em *Emulator) rawWire(b uint8) error {
+
+    switch {
+        case b == BINARY_RESET:
+            em.enterBitbang()
+        case b == GET_MODE:
+            em.writeString(MODE_RAW_REPLY)
+        case b == RAW_START, b == RAW_STOP, b == RAW_CS_LOW, b == RAW_CS_HIGH,
+            b == RAW_CLOCK_LOW, b == RAW_CLOCK_HIGH:
+            em.write(0x01)
+        case b == RAW_READ_BYTE:
+            em.write(em.rawReadByte())
+        case b == RAW_READ_BIT:
+            em.raw_data = 1
+            bit := em.rawPeek()
+            em.rawTick()
+            em.write(bit)
+        case b == RAW_PEEK:
+            em.write(em.rawPeek())
+        case b == RAW_CLOCK_TICK:
+            em.rawTick()
+            em.write(0x01)
+        case b == RAW_DATA_LOW, b == RAW_DATA_HIGH:
+            em.raw_data = b & 0x01
+            em.write(0x01)
+        case b & 0xF0 == RAW_BULK_TRANSFER:
+            data, err := em.readN(int(b & 0x0F) + 1)
+            if err != nil {
+                return err
+            }
+            res := []uint8{0x01}
+            for _, d := range data {
+                res = append(res, em.rawByte(d, 8))
+            }
+            em.write(res...)
+        case b & 0xF0 == RAW_BULK_CLOCK:
+            for i := 0; i <= int(b & 0x0F); i++ {
+                em.rawTick()
+            }
+            em.write(0x01)
+        case b & 0xF8 == RAW_BULK_BITS:
+            data, err := em.readN(1)
+            if err != nil {
+                return err
+            }
+            em.rawByte(data[0], int(b & 0x07) + 1)
+            em.write(0x01)
+        case b & 0xF0 == SET_PERIPH:
+            em.raw_periph = b & 0x0F
+            em.write(0x01)
+        case b & 0xFC == RAW_SET_SPEED:
+            em.raw_speed = b & 0x03
+            em.write(0x01)
+        case b & 0xF0 == RAW_SET_CONFIG:
+            em.raw_config = b & 0x0F
+            em.write(0x01)
+    }
+
+    return nil
+} //rawWire()
@@ -0,0 +1,91 @@
+package buspirate
+
+// SimSPIDevice is a device wired to the emulator's SPI pins. Select is
+// called when CS goes low (true) or high (false), Transfer for every byte
+// clocked out on MOSI and returns the byte the device drives on MISO.
+type SimSPIDevice interface {
+    Select(active bool)
+    Transfer(mosi uint8) uint8
+}
+
+func (em *Emulator) spiSelect(active bool) {
+    if em.spi_dev != nil {
+        em.spi_dev.Select(active)
+    }
+} //spiSelect()
+
+func (em *Emulator) spiTransfer(mosi uint8) uint8 {
+    // Without a device MISO floats high.
+    if em.spi_dev == nil {
+        return 0xFF
+    }
+    return em.spi_dev.Transfer(mosi)
+} //spiTransfer()
+
+func (em *Emulator) spi(b uint8) error {
+
+    switch {
+        case b == BINARY_RESET:
+            em.enterBitbang()
+        case b == MODE_SPI:
+            em.writeString(MODE_SPI_REPLY)
+        case b == SPI_CS_LOW:
+            em.spiSelect(true)
+            em.write(0x01)
+        case b == SPI_CS_HIGH:
+            em.spiSelect(false)
+            em.write(0x01)
+        case b == SPI_WRITE_READ, b == SPI_WRITE_READ_NO_CS:
+            lens, err := em.readN(4)
+            if err != nil {
+                return err
+            }
+            wlen := int(lens[0]) << 8 | int(lens[1])
+            rlen := int(lens[2]) << 8 | int(lens[3])
+            if wlen > SPI_WRITE_READ_MAX || rlen > SPI_WRITE_READ_MAX {
+                em.write(0x00)
+                return nil
+            }
+
+            data, err := em.readN(wlen)
+            if err != nil {
+                return err
+            }
+
+            if b == SPI_WRITE_READ {
+                em.spiSelect(true)
+            }
+            for _, d := range data {
+                em.spiTransfer(d)
+            }
+            res := []uint8{0x01}
+            for i := 0; i < rlen; i++ {
+                res = append(res, em.spiTransfer(0xFF))
+            }
+            if b == SPI_WRITE_READ {
+                em.spiSelect(false)
+            }
+            em.write(res...)
+        case b & 0xF0 == SPI_BULK_TRANSFER:
+            data, err := em.readN(int(b & 0x0F) + 1)
+            if err != nil {
+                return err
+            }
+            res := []uint8{0x01}
+            for _, d := range data {
+                res = append(res, em.spiTransfer(d))
+            }
+            em.write(res...)
+        case b & 0xF0 == SET_PERIPH:
+            em.spi_periph = b & 0x0F
+            em.write(0x01)
+        case b & 0xF8 == SPI_SET_SPEED:
+            em.spi_speed = b & 0x07
+            em.write(0x01)
+        case b & 0xF0 == SPI_SET_CONFIG:
+            em.spi_config = b & 0x0F
+            em.write(0x01)
+    }
+
+    return nil
+} //spi()
@@ -0,0 +1,71 @@
+package buspirate
+
+// UARTSend makes the emulated target transmit data to the Bus Pirate. It
+// reaches the host while echo is on or the UART is bridged, and is dropped
+// otherwise, like on the real hardware.
+func (em *Emulator) UARTSend(data []uint8) {
+    em.mu.Lock()
+    defer em.mu.Unlock()
+
+    if (em.mode == EMU_UART && em.uart_echo) || em.mode == EMU_UART_BRIDGE {
+        em.write(data...)
+    }
+} //UARTSend()
+
+// UARTReceived returns, and clears, everything the Bus Pirate has
+// transmitted to the emulated target.
+func (em *Emulator) UARTReceived() []uint8 {
+    em.mu.Lock()
+    defer em.mu.Unlock()
+
+    res := em.uart_tx
+    em.uart_tx = nil
+    return res
+} //UARTReceived()
+
+func (em *Emulator) uart(b uint8) error {
+
+    switch {
+        case b == BINARY_RESET:
+            em.enterBitbang()
+        case b == GET_MODE:
+            em.writeString(MODE_UART_REPLY)
+        case b == UART_START_ECHO:
+            em.uart_echo = true
+            em.write(0x01)
+        case b == UART_STOP_ECHO:
+            em.uart_echo = false
+            em.write(0x01)
+        case b == UART_SET_BRG:
+            brg, err := em.readN(2)
+            if err != nil {
+                return err
+            }
+            em.uart_brg = uint16(brg[0]) << 8 | uint16(brg[1])
+            em.write(0x01)
+        case b == UART_BRIDGE:
+            // No reply, and only a reset gets us out again.
+            em.mode = EMU_UART_BRIDGE
+        case b & 0xF0 == UART_BULK_WRITE:
+            data, err := em.readN(int(b & 0x0F) + 1)
+            if err != nil {
+                return err
+            }
+            em.uart_tx = append(em.uart_tx, data...)
+            em.write(0x01)
+            for range data {
+                em.write(0x01)
+            }
+        case b & 0xF0 == SET_PERIPH:
+            em.uart_periph = b & 0x0F
+            em.write(0x01)
+        case b & 0xF0 == UART_SET_BAUD:
+            em.uart_baud = b & 0x0F
+            em.write(0x01)
+        case b & 0xE0 == UART_SET_CONFIG:
+            em.uart_config = b & 0x1F
+            em.write(0x01)
+    }
+
+    return nil
+} //uart()
@@ -0,0 +1,53 @@
+package buspirate
+
+import (
+    "errors"
+    "fmt"
+)
+
+var (
+    // ErrTimeout is returned when the Bus Pirate doesn't answer, or doesn't
+    // finish answering, before the read timeout. Usually worth a retry.
+    ErrTimeout = errors.New("Timed out waiting for the Bus Pirate")
+
+    // ErrNotInMode is returned when a command is sent to a mode the BP
+    // isn't in, e.g. using an *I2C after ModeSPI.
+    ErrNotInMode = errors.New("Bus Pirate is not in the required mode")
+
+    // ErrClosed is returned by everything after BP.Close.
+    ErrClosed = errors.New("Bus Pirate is closed")
+
+    // ErrPEC is returned when an SMBus read's Packet Error Code doesn't
+    // match the bytes received. The data is returned anyway.
+    ErrPEC = errors.New("SMBus PEC mismatch")
+
+    // ErrUARTEcho is returned by UART commands other than Echo(false) while
+    // echo is on, their replies would be lost in the received data.
+    ErrUARTEcho = errors.New("UART echo is on, turn it off first")
+)
+
+// ErrUnexpectedReply is returned when the Bus Pirate answers a command with
+// something other than what was expected. Usually means the BP and this
+// package disagree about which mode it is in, a Reset is the best bet.
+type ErrUnexpectedReply struct {
+    Want []uint8
+    Got []uint8
+}
+
+func (e *ErrUnexpectedReply) Error() string {
+    return fmt.Sprintf("Unexpected reply, wanted: %q, got: %q", e.Want, e.Got)
+} //Error()
+
+// ErrNACK is returned when an I2C device doesn't ACK its address, or a byte
+// written to it. Addr is the 7-bit address, or the 10-bit one with TenBit.
+type ErrNACK struct {
+    Addr uint16
+    TenBit bool
+}
+
+func (e *ErrNACK) Error() string {
+    if e.TenBit {
+        return fmt.Sprintf("No ACK from 10-bit I2C address 0x%03X", e.Addr)
+    }
+    return fmt.Sprintf("No ACK from I2C address 0x%02X", e.Addr)
+} //Error()
@@ -2,9 +2,12 @@
 package buspirate
 
 import (
+    "context"
+    "encoding/binary"
     "log"
     "errors"
-    // "fmt"
+    "fmt"
+    "time"
 )
 
 const (
@@ -16,6 +19,7 @@ const (
     // 00000100 – I2C read byte
     // 00000110 – Send I2C ACK bit
     // 00000111 – Send I2C NACK bit
+    // 00001000 – Write then read, see WriteThenRead
     // 00001111 – Start bus sniffer
     // 0001xxxx – Bulk transfer, send 1-16 bytes (0=1byte!)
     // 0100wxyz – Configure peripherals w=power, x=pullups, y=AUX, z=CS
@@ -30,21 +34,53 @@ const (
     I2C_WRITE_BIT = 0x00
     I2C_SEND_ACK = 0x06
     I2C_SEND_NACK = 0x07
-    // I2C_SNIFFER = 0x0F
+    I2C_WRITE_READ = 0x08
+    I2C_WRITE_READ_MAX = 4096
+    // Worst case time for one byte on the bus, ms, at ~5kHz
+    I2C_BYTE_TIMEOUT = 2
+    I2C_SNIFFER = 0x0F
     I2C_BULK_SEND = 0x10
+    I2C_BULK_MAX = 16
     // I2C_SET_PERIPH = 0x40
-    I2C_PERIPH_POWER = 0x08
-    I2C_PERIPH_PULLUPS = 0x04
-    I2C_PERIPH_AUX = 0x02
-    I2C_PERIPH_CS = 0x01
+    // Kept for existing code, new code should use the shared PERIPH_* bits.
+    I2C_PERIPH_POWER = PERIPH_POWER
+    I2C_PERIPH_PULLUPS = PERIPH_PULLUPS
+    I2C_PERIPH_AUX = PERIPH_AUX
+    I2C_PERIPH_CS = PERIPH_CS
     I2C_SET_SPEED = 0x60
     I2C_SPEED_400 = 0x03
     I2C_SPEED_100 = 0x02
     I2C_SPEED_50 = 0x01
     I2C_SPEED_5 = 0x00
     I2C_MAX_ADDR = 127
+    // First byte of a 10-bit address, 11110xx0, xx the top two address bits
+    I2C_ADDR_10BIT = 0xF0
+    I2C_MAX_ADDR_10BIT = 0x3FF
 )
 
+// I2CSpeed is one of the I2C_SPEED_* bus speeds.
+type I2CSpeed uint8
+
+// The rates, in Hz, of the I2C_SPEED_* values.
+var i2cSpeedHz = map[I2CSpeed]int{
+    I2C_SPEED_5: 5000,
+    I2C_SPEED_50: 50000,
+    I2C_SPEED_100: 100000,
+    I2C_SPEED_400: 400000,
+}
+
+// The speeds, slowest first.
+var i2cSpeeds = []I2CSpeed{I2C_SPEED_5, I2C_SPEED_50, I2C_SPEED_100, I2C_SPEED_400}
+
+// Hz returns the bus rate of speed, 0 if it isn't a valid speed.
+func (speed I2CSpeed) Hz() int {
+    return i2cSpeedHz[speed]
+} //Hz()
+
+func (speed I2CSpeed) String() string {
+    return fmt.Sprintf("%dkHz", speed.Hz() / 1000)
+} //String()
+
 type I2C struct {
     Bp *BP
 }
@@ -53,204 +89,247 @@ func NewI2C(bp *BP) *I2C {
     return &I2C{Bp: bp}
 } //NewI2C()
 
+// acquire is BP.acquire for a I2C handle.
+func (i2c *I2C) acquire() (*I2C, func()) {
+    tx, unlock := i2c.Bp.acquire()
+    return &I2C{Bp: tx}, unlock
+} //acquire()
 
-func (i2c *I2C) setPeriph(bit uint8, on bool) error {
+// Transaction runs f with a handle that holds the BP, so nothing from other
+// goroutines gets in between its commands.
+func (i2c *I2C) Transaction(f func(tx *I2C) error) error {
+
+    tx, unlock := i2c.acquire()
+    defer unlock()
 
-    log.Printf("setPeriph %b\n", on)
+    return f(tx)
+} //Transaction()
 
-    if on {
-        return i2c.Bp.SetPinsIn(bit, string(0x01))
+
+// checkAddr checks a 7-bit address.
+func checkAddr(addr uint8) error {
+
+    if addr > I2C_MAX_ADDR {
+        return errors.New(fmt.Sprintf("Invalid I2C address: 0x%X", addr))
     }
 
-    return i2c.Bp.SetPinsOut(bit, string(0x01))
+    return nil
+} //checkAddr()
+
+// ConfigurePeripherals sets all of the I2C mode peripherals at once.
+func (i2c *I2C) ConfigurePeripherals(p Peripherals) error {
+    return i2c.Bp.configurePeripherals(MODE_I2C, p.bits())
+} //ConfigurePeripherals()
+
+// Peripherals returns the I2C mode peripherals as last set.
+func (i2c *I2C) Peripherals() Peripherals {
+    return i2c.Bp.peripherals(MODE_I2C)
+} //Peripherals()
+
+func (i2c *I2C) setPeriph(bit uint8, on bool) error {
+    return i2c.Bp.setPeriph(MODE_I2C, bit, on)
 } //setPeriph()
 
 func (i2c *I2C) Power(on bool) error {
-    log.Printf("Power %b\n", on)
-    return i2c.setPeriph(I2C_PERIPH_POWER, on)
+    log.Printf("Power %t\n", on)
+    return i2c.setPeriph(PERIPH_POWER, on)
 } //Power()
 
 func (i2c *I2C) Pullups(on bool) error {
-    log.Printf("Pullups %b\n", on)
-    return i2c.setPeriph(I2C_PERIPH_PULLUPS, on)
+    log.Printf("Pullups %t\n", on)
+    return i2c.setPeriph(PERIPH_PULLUPS, on)
 } //Pullups()
 
 func (i2c *I2C) AUX(on bool) error {
-    log.Printf("AUX %b\n", on)
-    return i2c.setPeriph(I2C_PERIPH_AUX, on)
+    log.Printf("AUX %t\n", on)
+    return i2c.setPeriph(PERIPH_AUX, on)
 } //AUX()
 
 
 func (i2c *I2C) CS(on bool) error {
-    log.Printf("CS %b\n", on)
-    return i2c.setPeriph(I2C_PERIPH_CS, on)
+    log.Printf("CS %t\n", on)
+    return i2c.setPeriph(PERIPH_CS, on)
 } //CS()
 
-// func (i2c *I2C) Sniff() error {
-// } //Sniff()
-
-func (i2c *I2C) Scan() []uint8 {
-    log.Printf("Scan()")
-
-    // For each address, 0-127
-    // Send a start bit, and the address twice:
-    // Once with a read bit, and once with a write bit.
-    // if we get an ACK, we consider it successful.
-
-    var res []uint8
-    var i, read_a, write_a uint8
-
-    testAddr := func(addr uint8) error {
-
-        bytes, err := i2c.Start()
-        var found bool = false
-
-        if err != nil {
-            log.Printf("Error while scanning: %s\n", err)
-            return err 
-        }
-
-        bytes, err = i2c.SendBytesTo(addr, make([]uint8, 0))
-        if err != nil {
-            log.Printf("Error while scanning, unable to write address: %s\n", err)
-            return err
-        }
-
-        // log.Printf("testAddr() bytes: %q", bytes)
-        if bytes[0] == 0 {
-            log.Printf(
-                "Scan() test got ACK addr: %2.2X, bytes: %q, err: %s",
-                i, bytes, err)
-            found = true
-        }
-
-        bytes, err = i2c.Stop(addr)
-        if err != nil {
-            return err
-        }
-        
-        if found {
-            return nil
-        }
-
-        return errors.New("No ACK")
-    } //testAddr()
-
-    // i2c.Bp.ReadTimeout = 100
-    for i = 0; i <= I2C_MAX_ADDR; i++ {
-
-        read_a = (i << 1) | I2C_READ_BIT
-        write_a = (i << 1) | I2C_WRITE_BIT  
-
-        log.Printf("Scan() write address %2.2X : %2.2X", i, write_a)
-        if testAddr(write_a) == nil {
-            log.Printf(
-                "Scan() writer at, addr: %2.2X",
-                i)
-            res = append(res, write_a)
-        }
+// cmd sends a single byte command that answers 0x01.
+func (i2c *I2C) cmd(c uint8) ([]uint8, error) {
 
+    bytes, err := i2c.Bp.modeWriteRead(MODE_I2C, []uint8{c}, 1)
+    if err != nil {
+        return bytes, err
+    }
 
-        log.Printf("Scan() read address %2.2X : %2.2X", i, read_a)
-        if testAddr(read_a) == nil {
-            log.Printf(
-                "Scan() reader at, addr: %2.2X",
-                i)
-            res = append(res, read_a)
-        }
+    if bytes[0] != 0x01 {
+        return bytes, &ErrUnexpectedReply{Want: []uint8{0x01}, Got: bytes}
     }
 
-    return res
-} //Scan()
+    return bytes, nil
+} //cmd()
 
 func (i2c *I2C) Start() ([]uint8, error) {
-    // bytes, err := i2c.Bp.writeFind([]uint8{I2C_SEND_START}, string(0x01))
-    // log.Printf("Start: got: %q, error: %s", bytes, err)
-    bytes, found, err := i2c.Bp.WriteReadCHK([]uint8{I2C_SEND_START}, string(0x01))
-    if !found {
-        return bytes, errors.New("Stop command did not respond correctly")
-    }
-    return bytes, err
+    return i2c.cmd(I2C_SEND_START)
 } //Start()
 
 func (i2c *I2C) Stop(addr uint8) ([]uint8, error) {
-    // bytes, err := i2c.Bp.writeFind([]uint8{I2C_SEND_STOP | addr}, string(0x01))
-    bytes, found, err := i2c.Bp.WriteReadCHK([]uint8{I2C_SEND_STOP}, string(0x01))
-    if !found {
-        return bytes, errors.New("Stop command did not respond correctly")
-    }
-    // log.Printf("Stop: got: %q", bytes)
-    return bytes, err
+    return i2c.cmd(I2C_SEND_STOP)
 } //Stop()
 
 func (i2c *I2C) ACK() error {
-    _, err := i2c.Bp.writeFind([]uint8{I2C_SEND_ACK}, string(0x01))
+    _, err := i2c.cmd(I2C_SEND_ACK)
     return err
 } //ACK()
 
 func (i2c *I2C) NACK() error {
-    _, err := i2c.Bp.writeFind([]uint8{I2C_SEND_NACK}, string(0x01))
+    _, err := i2c.cmd(I2C_SEND_NACK)
     return err
 } //NACK()
 
-func (i2c *I2C) ReadByte() (uint8, error) {
-    bytes, err := i2c.Bp.writeFind([]uint8{I2C_READ_BYTE}, "")
-    return bytes[0], err
-} //ReadByte()
+func (i2c *I2C) ReadByte() (byte, error) {
 
-func (i2c *I2C) ReadFrom(addr uint8) ([]uint8, error) {
-    // Send a START
-    // Send the address we want to READ from.
-    // Check the result of the ADDRESS, see if it's been ACK'ED
-    bytes, err := i2c.Start()
+    bytes, err := i2c.Bp.modeWriteRead(MODE_I2C, []uint8{I2C_READ_BYTE}, 1)
     if err != nil {
-        return bytes, err
+        return 0, err
+    }
+
+    return bytes[0], nil
+} //ReadByte()
+
+// ReadFrom reads n bytes from the device at 7-bit address addr: START, the
+// address, n reads with every byte but the last ACKed, then STOP. If the
+// address isn't ACKed the error is an ErrNACK.
+func (i2c *I2C) ReadFrom(addr uint8, n int) ([]uint8, error) {
+
+    if err := checkAddr(addr); err != nil {
+        return nil, err
     }
+    if n < 1 {
+        return nil, errors.New("Must read at least 1 byte")
+    }
+
+    i2c, unlock := i2c.acquire()
+    defer unlock()
 
-    bytes, err = i2c.SendBytesTo(addr, make([]uint8, 0))
+    _, err := i2c.Start()
     if err != nil {
-        return bytes, err
+        return nil, err
     }
-    // log.Printf("ReadFrom, sent address, bytes: %q, err: %s", bytes, err)
 
-    return bytes, nil
+    res, err := i2c.readFrom(addr << 1 | I2C_READ_BIT, n)
+
+    // Always try to leave the bus idle.
+    _, stop_err := i2c.Stop(addr)
+    if err == nil {
+        err = stop_err
+    }
+
+    return res, err
 } //ReadFrom()
 
-func (i2c *I2C) setSpeed(speed uint8) error {
-    _, err := i2c.Bp.writeFind([]uint8{I2C_SET_SPEED | speed}, string(0x01))
+// readFrom addresses a device, after a START, and reads n bytes from it.
+func (i2c *I2C) readFrom(addr uint8, n int) ([]uint8, error) {
+
+    acks, err := i2c.SendBytesTo(addr, nil)
+    if err != nil {
+        return nil, err
+    }
+    if acks[0] != 0x00 {
+        return nil, &ErrNACK{Addr: uint16(addr >> 1)}
+    }
+
+    return i2c.readBytes(n, true)
+} //readFrom()
+
+// readBytes reads n bytes, ACKing each so the device sends more, except the
+// last when last is set: that one is NACKed so the device lets go of the bus.
+func (i2c *I2C) readBytes(n int, last bool) ([]uint8, error) {
+
+    res := make([]uint8, 0, n)
+    for len(res) < n {
+        b, err := i2c.ReadByte()
+        if err != nil {
+            return res, err
+        }
+        res = append(res, b)
+
+        if last && len(res) == n {
+            err = i2c.NACK()
+        } else {
+            err = i2c.ACK()
+        }
+        if err != nil {
+            return res, err
+        }
+    }
+
+    return res, nil
+} //readBytes()
+
+// SetSpeed sets the bus speed, one of the I2C_SPEED_* values. It is kept
+// when I2C mode is entered again.
+func (i2c *I2C) SetSpeed(speed I2CSpeed) error {
+
+    i2c, unlock := i2c.acquire()
+    defer unlock()
+
+    if _, ok := i2cSpeedHz[speed]; !ok {
+        return errors.New(fmt.Sprintf("Invalid I2C speed: %d", speed))
+    }
+
+    _, err := i2c.cmd(I2C_SET_SPEED | uint8(speed))
     if err != nil {
         return err
     }
 
+    i2c.Bp.speed[MODE_I2C] = uint8(speed)
     return nil
-} //setSpeed()
+} //SetSpeed()
 
-func (i2c *I2C) SetSpeed5() error {
-   return i2c.setSpeed(I2C_SPEED_5)
-} //SetSpeed5()
+// Speed returns the last speed set with SetSpeed.
+func (i2c *I2C) Speed() I2CSpeed {
+    i2c, unlock := i2c.acquire()
+    defer unlock()
 
-func (i2c *I2C) SetSpeed50() error {
-   return i2c.setSpeed(I2C_SPEED_50)
-} //SetSpeed50()
+    return I2CSpeed(i2c.Bp.speed[MODE_I2C])
+} //Speed()
 
-func (i2c *I2C) SetSpeed100() error {
-   return i2c.setSpeed(I2C_SPEED_100)
-} //SetSpeed100()
+// SetSpeedHz sets the available speed nearest to hz, the slower one if hz is
+// halfway between two, and returns it.
+func (i2c *I2C) SetSpeedHz(hz int) (I2CSpeed, error) {
 
-func (i2c *I2C) SetSpeed400() error {
-   return i2c.setSpeed(I2C_SPEED_400)
-} //SetSpeed400()
+    if hz <= 0 {
+        return 0, errors.New(fmt.Sprintf("Invalid I2C frequency: %d", hz))
+    }
+
+    best := i2cSpeeds[0]
+    for _, speed := range i2cSpeeds {
+        if abs(speed.Hz() - hz) < abs(best.Hz() - hz) {
+            best = speed
+        }
+    }
+
+    return best, i2c.SetSpeed(best)
+} //SetSpeedHz()
+
+func abs(v int) int {
+    if v < 0 {
+        return -v
+    }
+    return v
+} //abs()
 
 func (i2c *I2C) SendBytes(bytes []uint8) ([]uint8, error) {
 
     // log.Printf("SendBytes() bytes: %q", bytes)
-    if len(bytes) > 16 {
-        return nil, errors.New("Can't send more than 16 bytes at a time")
+    if len(bytes) > I2C_BULK_MAX {
+        return nil, errors.New(fmt.Sprintf("Can't send more than %d bytes at a time", I2C_BULK_MAX))
+    }
+
+    if len(bytes) < 1 {
+        return nil, errors.New("Must send at least 1 byte")
     }
 
     var res []uint8
-    sending := make([]uint8, len(bytes))
-    sending[0] = I2C_BULK_SEND | uint8(len(bytes)-1)
+    sending := []uint8{I2C_BULK_SEND | uint8(len(bytes)-1)}
     sending = append(sending, bytes...)
 
     // log.Printf("SendBytes writing send byte: %2.2X", I2C_BULK_SEND | uint8(len(bytes)-1))
@@ -277,7 +356,8 @@ func (i2c *I2C) SendBytes(bytes []uint8) ([]uint8, error) {
 
     // log.Printf("SendBytes sending: %2.2X", sending)
     // for i:=0; i<len(sending); i++ {
-    recvd, err := bp.writeFind(sending, "")
+    // One reply byte for the command, one ACK/NACK per byte sent.
+    recvd, err := bp.modeWriteRead(MODE_I2C, sending, len(sending))
     res = append(res, recvd...)
     if err != nil {
         return res, err
@@ -289,6 +369,10 @@ func (i2c *I2C) SendBytes(bytes []uint8) ([]uint8, error) {
     //     return recvd[1:], err
     // }
 
+    if res[0] != 0x01 {
+        return res, &ErrUnexpectedReply{Want: []uint8{0x01}, Got: res[:1]}
+    }
+
     // log.Printf("SendBytes got: %2.2X", res)
     return res[1:], nil
 } //SendBytes()
@@ -299,3 +383,382 @@ func (i2c *I2C) SendBytesTo(addr uint8, bytes []uint8) ([]uint8, error) {
     ad[0] = addr
     return i2c.SendBytes(append(ad, bytes...))
 } //SendBytesTo()
+
+// WriteThenRead runs a whole transaction on the Bus Pirate: START, write w,
+// read n bytes ACKing all but the last, then STOP. w starts with the address
+// byte, and both w and n are limited to 4096. If any byte written is NACKed
+// the Bus Pirate gives up, and the error is an ErrNACK.
+func (i2c *I2C) WriteThenRead(w []uint8, n int) ([]uint8, error) {
+
+    if len(w) < 1 || len(w) > I2C_WRITE_READ_MAX || n < 0 || n > I2C_WRITE_READ_MAX {
+        return nil, errors.New(fmt.Sprintf(
+            "Must write 1 to %d bytes and read 0 to %d", I2C_WRITE_READ_MAX, I2C_WRITE_READ_MAX))
+    }
+
+    i2c, unlock := i2c.acquire()
+    defer unlock()
+
+    bp := i2c.Bp
+    if err := bp.checkMode(MODE_I2C); err != nil {
+        return nil, err
+    }
+
+    sending := []uint8{I2C_WRITE_READ,
+        uint8(len(w) >> 8), uint8(len(w)),
+        uint8(n >> 8), uint8(n)}
+    sending = append(sending, w...)
+
+    // Give the bus time to clock it all through.
+    timeout := bp.ReadTimeout + time.Duration(len(w) + n) * I2C_BYTE_TIMEOUT * time.Millisecond
+    ctx, cancel := context.WithTimeout(context.Background(), timeout)
+    defer cancel()
+
+    res, err := bp.writeReadCtx(ctx, sending, 1)
+    if err != nil {
+        return nil, err
+    }
+    if res[0] == 0x00 {
+        return nil, &ErrNACK{Addr: uint16(w[0] >> 1)}
+    }
+    if res[0] != 0x01 {
+        return nil, &ErrUnexpectedReply{Want: []uint8{0x01}, Got: res}
+    }
+
+    return bp.ReadExactly(ctx, n)
+} //WriteThenRead()
+
+// Tx writes w to the device at 7-bit address addr, then reads len(r) bytes
+// from it into r. Either can be empty. The write and the read are each a
+// WriteThenRead, so there is a STOP between them rather than a repeated
+// START; devices that need a repeated START before the read have to be driven
+// with Start, SendBytesTo, ReadByte, ACK/NACK and Stop instead. The address
+// byte counts toward WriteThenRead's 4096 bytes, so w is at most 4095 bytes,
+// r at most 4096.
+func (i2c *I2C) Tx(addr uint8, w, r []byte) error {
+
+    if err := checkAddr(addr); err != nil {
+        return err
+    }
+
+    if len(w) > I2C_WRITE_READ_MAX - 1 || len(r) > I2C_WRITE_READ_MAX {
+        return errors.New(fmt.Sprintf("Tx writes at most %d bytes and reads at most %d, got %d and %d",
+            I2C_WRITE_READ_MAX - 1, I2C_WRITE_READ_MAX, len(w), len(r)))
+    }
+
+    i2c, unlock := i2c.acquire()
+    defer unlock()
+
+    if len(w) > 0 || len(r) == 0 {
+        _, err := i2c.WriteThenRead(append([]uint8{addr << 1 | I2C_WRITE_BIT}, w...), 0)
+        if err != nil {
+            return err
+        }
+    }
+
+    if len(r) > 0 {
+        res, err := i2c.WriteThenRead([]uint8{addr << 1 | I2C_READ_BIT}, len(r))
+        copy(r, res)
+        if err != nil {
+            return err
+        }
+    }
+
+    return nil
+} //Tx()
+
+// writeTo sends the address byte and then data, after a START, in bulk writes
+// of up to 16 bytes. Any byte not ACKed is an ErrNACK.
+func (i2c *I2C) writeTo(addr uint8, data []uint8) error {
+    sending := append([]uint8{addr}, data...)
+    return i2c.writeAll(sending, &ErrNACK{Addr: uint16(addr >> 1)})
+} //writeTo()
+
+// writeAll sends bytes in bulk writes of up to 16 bytes, returning nack if
+// any of them isn't ACKed.
+func (i2c *I2C) writeAll(sending []uint8, nack *ErrNACK) error {
+
+    for len(sending) > 0 {
+        chunk := sending
+        if len(chunk) > I2C_BULK_MAX {
+            chunk = chunk[:I2C_BULK_MAX]
+        }
+        sending = sending[len(chunk):]
+
+        acks, err := i2c.SendBytes(chunk)
+        if err != nil {
+            return err
+        }
+        for _, ack := range acks {
+            if ack != 0x00 {
+                return nack
+            }
+        }
+    }
+
+    return nil
+} //writeAll()
+
+// readRegs writes the register pointer reg, then after a repeated START
+// reads len(buf) bytes into buf.
+func (i2c *I2C) readRegs(addr uint8, reg []uint8, buf []uint8) error {
+
+    if err := checkAddr(addr); err != nil {
+        return err
+    }
+    if len(buf) < 1 {
+        return errors.New("Must read at least 1 byte")
+    }
+
+    i2c, unlock := i2c.acquire()
+    defer unlock()
+
+    _, err := i2c.Start()
+    if err != nil {
+        return err
+    }
+
+    err = i2c.writeTo(addr << 1 | I2C_WRITE_BIT, reg)
+    if err == nil {
+        _, err = i2c.Start()
+    }
+    if err == nil {
+        var res []uint8
+        res, err = i2c.readFrom(addr << 1 | I2C_READ_BIT, len(buf))
+        copy(buf, res)
+    }
+
+    _, stop_err := i2c.Stop(addr)
+    if err == nil {
+        err = stop_err
+    }
+
+    return err
+} //readRegs()
+
+// writeRegs writes the register pointer reg followed by data.
+func (i2c *I2C) writeRegs(addr uint8, reg []uint8, data []uint8) error {
+
+    if err := checkAddr(addr); err != nil {
+        return err
+    }
+
+    i2c, unlock := i2c.acquire()
+    defer unlock()
+
+    _, err := i2c.Start()
+    if err != nil {
+        return err
+    }
+
+    err = i2c.writeTo(addr << 1 | I2C_WRITE_BIT, append(reg, data...))
+
+    _, stop_err := i2c.Stop(addr)
+    if err == nil {
+        err = stop_err
+    }
+
+    return err
+} //writeRegs()
+
+// ReadRegs reads len(buf) consecutive registers, starting at reg, from the
+// device at 7-bit address addr.
+func (i2c *I2C) ReadRegs(addr uint8, reg uint8, buf []byte) error {
+    return i2c.readRegs(addr, []uint8{reg}, buf)
+} //ReadRegs()
+
+// WriteRegs writes data to consecutive registers starting at reg.
+func (i2c *I2C) WriteRegs(addr uint8, reg uint8, data []byte) error {
+    return i2c.writeRegs(addr, []uint8{reg}, data)
+} //WriteRegs()
+
+// ReadRegsAddr16 is ReadRegs for devices with a 16-bit register address,
+// sent MSB first, like most EEPROMs bigger than 2K.
+func (i2c *I2C) ReadRegsAddr16(addr uint8, reg uint16, buf []byte) error {
+    return i2c.readRegs(addr, []uint8{uint8(reg >> 8), uint8(reg)}, buf)
+} //ReadRegsAddr16()
+
+// WriteRegsAddr16 is WriteRegs for devices with a 16-bit register address.
+func (i2c *I2C) WriteRegsAddr16(addr uint8, reg uint16, data []byte) error {
+    return i2c.writeRegs(addr, []uint8{uint8(reg >> 8), uint8(reg)}, data)
+} //WriteRegsAddr16()
+
+func (i2c *I2C) ReadReg8(addr uint8, reg uint8) (uint8, error) {
+
+    buf := make([]uint8, 1)
+    err := i2c.ReadRegs(addr, reg, buf)
+
+    return buf[0], err
+} //ReadReg8()
+
+func (i2c *I2C) WriteReg8(addr uint8, reg uint8, val uint8) error {
+    return i2c.WriteRegs(addr, reg, []uint8{val})
+} //WriteReg8()
+
+// ReadReg16 reads a 16-bit register, in the byte order the device uses.
+func (i2c *I2C) ReadReg16(addr uint8, reg uint8, order binary.ByteOrder) (uint16, error) {
+
+    buf := make([]uint8, 2)
+    err := i2c.ReadRegs(addr, reg, buf)
+    if err != nil {
+        return 0, err
+    }
+
+    return order.Uint16(buf), nil
+} //ReadReg16()
+
+// WriteReg16 writes a 16-bit register, in the byte order the device uses.
+func (i2c *I2C) WriteReg16(addr uint8, reg uint8, val uint16, order binary.ByteOrder) error {
+
+    buf := make([]uint8, 2)
+    order.PutUint16(buf, val)
+
+    return i2c.WriteRegs(addr, reg, buf)
+} //WriteReg16()
+
+// UpdateBits sets the bits of mask in an 8-bit register to those in val,
+// leaving the rest alone. Nothing else gets at the bus between the read and
+// the write.
+func (i2c *I2C) UpdateBits(addr uint8, reg uint8, mask uint8, val uint8) error {
+
+    i2c, unlock := i2c.acquire()
+    defer unlock()
+
+    old, err := i2c.ReadReg8(addr, reg)
+    if err != nil {
+        return err
+    }
+
+    updated := old &^ mask | val & mask
+    if updated == old {
+        return nil
+    }
+
+    return i2c.WriteReg8(addr, reg, updated)
+} //UpdateBits()
+
+// checkAddr10 checks a 10-bit address.
+func checkAddr10(addr uint16) error {
+
+    if addr > I2C_MAX_ADDR_10BIT {
+        return errors.New(fmt.Sprintf("Invalid 10-bit I2C address: 0x%X", addr))
+    }
+
+    return nil
+} //checkAddr10()
+
+// addr10 returns the two bytes that address a 10-bit device for writing.
+// For reading, the first is sent again with the read bit after a repeated
+// START.
+func addr10(addr uint16) []uint8 {
+    return []uint8{I2C_ADDR_10BIT | uint8(addr >> 8) << 1 | I2C_WRITE_BIT, uint8(addr)}
+} //addr10()
+
+// writeTo10 addresses a 10-bit device, after a START, and writes data to it.
+func (i2c *I2C) writeTo10(addr uint16, data []uint8) error {
+    sending := append(addr10(addr), data...)
+    return i2c.writeAll(sending, &ErrNACK{Addr: addr, TenBit: true})
+} //writeTo10()
+
+// readFrom10 addresses a 10-bit device, after a START, and reads n bytes from
+// it. A read needs the whole address written first, then a repeated START
+// and the first address byte again with the read bit.
+func (i2c *I2C) readFrom10(addr uint16, n int) ([]uint8, error) {
+
+    if err := i2c.writeTo10(addr, nil); err != nil {
+        return nil, err
+    }
+
+    _, err := i2c.Start()
+    if err != nil {
+        return nil, err
+    }
+
+    return i2c.readHeader10(addr, n)
+} //readFrom10()
+
+// readHeader10 reads n bytes from a 10-bit device that was just addressed for
+// writing, after the repeated START: only the first address byte is sent
+// again, with the read bit.
+func (i2c *I2C) readHeader10(addr uint16, n int) ([]uint8, error) {
+
+    acks, err := i2c.SendBytesTo(addr10(addr)[0] | I2C_READ_BIT, nil)
+    if err != nil {
+        return nil, err
+    }
+    if acks[0] != 0x00 {
+        return nil, &ErrNACK{Addr: addr, TenBit: true}
+    }
+
+    return i2c.readBytes(n, true)
+} //readHeader10()
+
+// ReadFrom10 is ReadFrom for a device with a 10-bit address.
+func (i2c *I2C) ReadFrom10(addr uint16, n int) ([]uint8, error) {
+
+    if err := checkAddr10(addr); err != nil {
+        return nil, err
+    }
+    if n < 1 {
+        return nil, errors.New("Must read at least 1 byte")
+    }
+
+    i2c, unlock := i2c.acquire()
+    defer unlock()
+
+    _, err := i2c.Start()
+    if err != nil {
+        return nil, err
+    }
+
+    res, err := i2c.readFrom10(addr, n)
+
+    _, stop_err := i2c.Stop(0)
+    if err == nil {
+        err = stop_err
+    }
+
+    return res, err
+} //ReadFrom10()
+
+// Tx10 writes w to the device at 10-bit address addr, then reads len(r)
+// bytes from it into r. Either can be empty. Unlike Tx the read follows the
+// write after a repeated START, as 10-bit devices expect.
+func (i2c *I2C) Tx10(addr uint16, w, r []byte) error {
+
+    if err := checkAddr10(addr); err != nil {
+        return err
+    }
+
+    i2c, unlock := i2c.acquire()
+    defer unlock()
+
+    _, err := i2c.Start()
+    if err != nil {
+        return err
+    }
+
+    var res []uint8
+    switch {
+        case len(r) == 0:
+            err = i2c.writeTo10(addr, w)
+        case len(w) == 0:
+            res, err = i2c.readFrom10(addr, len(r))
+        default:
+            // S, address, w, Sr, then just the first address byte to read.
+            err = i2c.writeTo10(addr, w)
+            if err == nil {
+                _, err = i2c.Start()
+            }
+            if err == nil {
+                res, err = i2c.readHeader10(addr, len(r))
+            }
+    }
+    copy(r, res)
+
+    _, stop_err := i2c.Stop(0)
+    if err == nil {
+        err = stop_err
+    }
+
+    return err
+} //Tx10()
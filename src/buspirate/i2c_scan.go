@@ -0,0 +1,271 @@
+package buspirate
+
+import (
+    "context"
+    "errors"
+)
+
+const (
+    // 0x00-0x07 and 0x78-0x7F are reserved: general call, CBUS, HS mode
+    // master codes, 10-bit addressing and the like.
+    I2C_FIRST_ADDR = 0x08
+    I2C_LAST_ADDR = 0x77
+)
+
+// ScanOptions picks what Scan probes and how. The zero value probes the
+// non-reserved addresses 0x08-0x77 for both reading and writing.
+type ScanOptions struct {
+    // With Range set, First and Last are the range of 7-bit addresses to
+    // probe, reserved ones included. Otherwise 0x08-0x77.
+    Range bool
+    First uint8
+    Last uint8
+    // Skip lists 7-bit addresses not to probe, e.g. devices known to
+    // misbehave when read.
+    Skip []uint8
+    // With TenBit set, the 10-bit addresses First10 to Last10 are probed
+    // too, after the 7-bit ones, except those in Skip10. Identify doesn't
+    // apply to them, I2CFingerprints only knows 7-bit devices.
+    TenBit bool
+    First10 uint16
+    Last10 uint16
+    Skip10 []uint16
+    // WriteOnly only probes each address for writing, half the bus traffic,
+    // and never has a device drive the bus.
+    WriteOnly bool
+    // Identify tries to name each device found, see I2CFingerprints.
+    Identify bool
+}
+
+// ScanResult is a device found by Scan. Device is its name if identified,
+// Confirmed is set when an ID register matched rather than just the address.
+// A 10-bit device has TenBit set and its address in Addr10, not Addr7.
+type ScanResult struct {
+    Addr7 uint8
+    TenBit bool
+    Addr10 uint16
+    ReadAck bool
+    WriteAck bool
+    Device string
+    Confirmed bool
+}
+
+// I2CFingerprint identifies a device by its address and, if Mask isn't zero,
+// by the value of an ID register: Reg read back and masked with Mask must be
+// Value. Safe is set for devices that a register pointer write leaves as they
+// were, reading an ID register writes one to whatever is at the address.
+type I2CFingerprint struct {
+    Name string
+    Addrs []uint8
+    Reg uint8
+    Mask uint8
+    Value uint8
+    Safe bool
+}
+
+// I2CFingerprints are the devices Scan knows. Entries with an ID register are
+// tried first, the others are only a guess from the address. ID registers are
+// only read at an address where every device listed is Safe.
+var I2CFingerprints = []I2CFingerprint{
+    {Name: "BME280", Addrs: []uint8{0x76, 0x77}, Reg: 0xD0, Mask: 0xFF, Value: 0x60, Safe: true},
+    {Name: "BMP280", Addrs: []uint8{0x76, 0x77}, Reg: 0xD0, Mask: 0xFF, Value: 0x58, Safe: true},
+    {Name: "BMP180", Addrs: []uint8{0x77}, Reg: 0xD0, Mask: 0xFF, Value: 0x55, Safe: true},
+    {Name: "MPU-6050", Addrs: []uint8{0x68, 0x69}, Reg: 0x75, Mask: 0x7E, Value: 0x68, Safe: true},
+    {Name: "MPU-9250", Addrs: []uint8{0x68, 0x69}, Reg: 0x75, Mask: 0xFF, Value: 0x71, Safe: true},
+    {Name: "ADXL345", Addrs: []uint8{0x1D, 0x53}, Reg: 0x00, Mask: 0xFF, Value: 0xE5, Safe: true},
+    {Name: "LIS3DH", Addrs: []uint8{0x18, 0x19}, Reg: 0x0F, Mask: 0xFF, Value: 0x33, Safe: true},
+    {Name: "HMC5883L", Addrs: []uint8{0x1E}, Reg: 0x0A, Mask: 0xFF, Value: 'H', Safe: true},
+    {Name: "VL53L0X", Addrs: []uint8{0x29}, Reg: 0xC0, Mask: 0xFF, Value: 0xEE, Safe: true},
+    {Name: "CCS811", Addrs: []uint8{0x5A, 0x5B}, Reg: 0x20, Mask: 0xFF, Value: 0x81, Safe: true},
+    {Name: "MCP9808", Addrs: []uint8{0x18, 0x19, 0x1A, 0x1B, 0x1C, 0x1D, 0x1E, 0x1F},
+        Reg: 0x07, Mask: 0xFF, Value: 0x04, Safe: true},
+
+    {Name: "MCP23017/MCP23008", Addrs: []uint8{0x20, 0x21, 0x22, 0x23, 0x24, 0x25, 0x26, 0x27},
+        Safe: true},
+    // The first byte written is a control byte.
+    {Name: "SSD1306", Addrs: []uint8{0x3C, 0x3D}},
+    {Name: "PCA9685", Addrs: []uint8{0x40, 0x41, 0x42, 0x43, 0x44, 0x45, 0x46, 0x47}, Safe: true},
+    // Commands, not registers.
+    {Name: "SHT3x", Addrs: []uint8{0x44, 0x45}},
+    {Name: "24Cxx EEPROM", Addrs: []uint8{0x50, 0x51, 0x52, 0x53, 0x54, 0x55, 0x56, 0x57},
+        Safe: true},
+    {Name: "DS1307/DS3231", Addrs: []uint8{0x68}, Safe: true},
+    // Any byte written is the channel mask.
+    {Name: "TCA9548A", Addrs: []uint8{0x70, 0x71, 0x72, 0x73, 0x74, 0x75, 0x76, 0x77}},
+}
+
+// Scan probes each address in opts' range with a single write-then-read
+// command: a write of just the address, and unless WriteOnly, a read of one
+// byte. It stops early, returning what it found so far, if ctx is done.
+// The BP is only held for each probe, so other goroutines can get in.
+func (i2c *I2C) Scan(ctx context.Context, opts ScanOptions) ([]ScanResult, error) {
+
+    first, last := uint8(I2C_FIRST_ADDR), uint8(I2C_LAST_ADDR)
+    if opts.Range {
+        first, last = opts.First, opts.Last
+    }
+    if err := checkAddr(last); err != nil {
+        return nil, err
+    }
+    if opts.TenBit {
+        if err := checkAddr10(opts.Last10); err != nil {
+            return nil, err
+        }
+    }
+
+    skip := make(map[uint8]bool)
+    for _, a := range opts.Skip {
+        skip[a] = true
+    }
+
+    var res []ScanResult
+    for addr := int(first); addr <= int(last); addr++ {
+        if err := ctx.Err(); err != nil {
+            return res, err
+        }
+        if skip[uint8(addr)] {
+            continue
+        }
+
+        r := ScanResult{Addr7: uint8(addr)}
+
+        var err error
+        r.WriteAck, err = i2c.probe(uint8(addr) << 1 | I2C_WRITE_BIT, 0)
+        if err != nil {
+            return res, err
+        }
+        if !opts.WriteOnly {
+            r.ReadAck, err = i2c.probe(uint8(addr) << 1 | I2C_READ_BIT, 1)
+            if err != nil {
+                return res, err
+            }
+        }
+
+        if !r.WriteAck && !r.ReadAck {
+            continue
+        }
+
+        if opts.Identify && r.WriteAck {
+            r.Device, r.Confirmed = i2c.identify(r.Addr7)
+        }
+        res = append(res, r)
+    }
+
+    if !opts.TenBit {
+        return res, nil
+    }
+
+    skip10 := make(map[uint16]bool)
+    for _, a := range opts.Skip10 {
+        skip10[a] = true
+    }
+
+    for addr := int(opts.First10); addr <= int(opts.Last10); addr++ {
+        if err := ctx.Err(); err != nil {
+            return res, err
+        }
+        if skip10[uint16(addr)] {
+            continue
+        }
+
+        r := ScanResult{TenBit: true, Addr10: uint16(addr)}
+
+        var err error
+        r.WriteAck, err = i2c.probe10(uint16(addr), false)
+        if err != nil {
+            return res, err
+        }
+        if !opts.WriteOnly {
+            r.ReadAck, err = i2c.probe10(uint16(addr), true)
+            if err != nil {
+                return res, err
+            }
+        }
+
+        if r.WriteAck || r.ReadAck {
+            res = append(res, r)
+        }
+    }
+
+    return res, nil
+} //Scan()
+
+// probe sends the address byte addr, reading n bytes if it's a read, and
+// reports if it was ACKed.
+func (i2c *I2C) probe(addr uint8, n int) (bool, error) {
+
+    _, err := i2c.WriteThenRead([]uint8{addr}, n)
+
+    var nack *ErrNACK
+    if errors.As(err, &nack) {
+        return false, nil
+    }
+
+    return err == nil, err
+} //probe()
+
+// fingerprintsAt returns the I2CFingerprints of the devices that might be at
+// addr, and whether all of them are Safe.
+func fingerprintsAt(addr uint8) ([]I2CFingerprint, bool) {
+
+    var fps []I2CFingerprint
+    safe := true
+    for _, fp := range I2CFingerprints {
+        for _, a := range fp.Addrs {
+            if a == addr {
+                fps = append(fps, fp)
+                safe = safe && fp.Safe
+                break
+            }
+        }
+    }
+
+    return fps, safe
+} //fingerprintsAt()
+
+// probe10 addresses the 10-bit device addr for writing, or reading a byte,
+// and reports if it was ACKed.
+func (i2c *I2C) probe10(addr uint16, read bool) (bool, error) {
+
+    var err error
+    if read {
+        _, err = i2c.ReadFrom10(addr, 1)
+    } else {
+        err = i2c.Tx10(addr, nil, nil)
+    }
+
+    var nack *ErrNACK
+    if errors.As(err, &nack) {
+        return false, nil
+    }
+
+    return err == nil, err
+} //probe10()
+
+// identify names the device at addr from I2CFingerprints, checking ID
+// registers, if that's safe, before falling back to a guess from the address
+// alone.
+func (i2c *I2C) identify(addr uint8) (string, bool) {
+
+    fps, safe := fingerprintsAt(addr)
+
+    guess := ""
+    for _, fp := range fps {
+        if fp.Mask == 0 {
+            if guess == "" {
+                guess = fp.Name
+            }
+            continue
+        }
+        if !safe {
+            continue
+        }
+
+        id, err := i2c.ReadReg8(addr, fp.Reg)
+        if err == nil && id & fp.Mask == fp.Value {
+            return fp.Name, true
+        }
+    }
+
+    return guess, false
+} //identify()
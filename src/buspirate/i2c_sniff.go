@@ -0,0 +1,149 @@
+package buspirate
+
+import (
+    "context"
+    "log"
+    "time"
+)
+
+const (
+    // Sniffer output: [ is a START or repeated START, ] a STOP, \ escapes the
+    // byte after it, which is followed by + for an ACK or - for a NACK.
+    I2C_SNIFF_START = '['
+    I2C_SNIFF_STOP = ']'
+    I2C_SNIFF_ESCAPE = '\\'
+    I2C_SNIFF_ACK = '+'
+    I2C_SNIFF_NACK = '-'
+    // Any byte sent to the Bus Pirate ends sniffing.
+    I2C_SNIFF_EXIT = 0xFF
+)
+
+// I2CSniffByte is a data byte seen on the bus and whether it was ACKed.
+type I2CSniffByte struct {
+    Data uint8
+    ACK bool
+}
+
+// I2CSniffTx is one transaction seen by the sniffer, from a START to a STOP
+// or to the next repeated START. A 10-bit address shows up as its 11110xx
+// header in Addr with the low address byte first in Data.
+type I2CSniffTx struct {
+    Addr uint8
+    Read bool
+    AddrACK bool
+    Data []I2CSniffByte
+    // Repeated is set when the transaction began with a repeated START.
+    Repeated bool
+    // Stopped is set when the transaction ended with a STOP, rather than a
+    // repeated START or the end of sniffing.
+    Stopped bool
+    Start time.Time
+    End time.Time
+
+    addressed bool
+}
+
+// Sniff puts the Bus Pirate into the I2C sniffer and returns the transactions
+// it sees. The BP is held until ctx is done, then the sniffer is stopped, the
+// BP is back in I2C mode and the channel is closed. Bytes seen before the
+// first START are dropped.
+func (i2c *I2C) Sniff(ctx context.Context) (<-chan I2CSniffTx, error) {
+
+    i2c, unlock := i2c.acquire()
+
+    err := i2c.Bp.modeWriteOK(MODE_I2C, []uint8{I2C_SNIFFER})
+    if err != nil {
+        unlock()
+        return nil, err
+    }
+
+    ch := make(chan I2CSniffTx)
+    go func() {
+        defer close(ch)
+        defer unlock()
+
+        i2c.sniff(ctx, ch)
+
+        // Whatever stopped us, try to leave the BP in I2C mode.
+        _, err := i2c.Bp.write([]uint8{I2C_SNIFF_EXIT})
+        if err == nil {
+            i2c.Bp.drain()
+        }
+    }()
+
+    return ch, nil
+} //Sniff()
+
+// sniff parses the sniffer output into ch until ctx is done or a read fails.
+func (i2c *I2C) sniff(ctx context.Context, ch chan<- I2CSniffTx) {
+
+    var cur *I2CSniffTx
+    var data uint8
+    var escaped, pending bool
+
+    emit := func(now time.Time) bool {
+        cur.End = now
+        select {
+            case ch <- *cur:
+                return true
+            case <-ctx.Done():
+                return false
+        }
+    }
+
+    for {
+        b, err := i2c.Bp.readByte(ctx)
+        if err != nil {
+            if ctx.Err() == nil {
+                log.Printf("Sniff stopped: %s\n", err)
+            }
+            return
+        }
+        now := time.Now()
+
+        if escaped {
+            escaped = false
+            data = b
+            pending = true
+            continue
+        }
+
+        switch b {
+            case I2C_SNIFF_START:
+                repeated := cur != nil
+                if repeated && !emit(now) {
+                    return
+                }
+                cur = &I2CSniffTx{Start: now, Repeated: repeated}
+                pending = false
+            case I2C_SNIFF_STOP:
+                if cur != nil {
+                    cur.Stopped = true
+                    if !emit(now) {
+                        return
+                    }
+                }
+                cur = nil
+                pending = false
+            case I2C_SNIFF_ESCAPE:
+                escaped = true
+            case I2C_SNIFF_ACK, I2C_SNIFF_NACK:
+                if cur == nil || !pending {
+                    continue
+                }
+                pending = false
+
+                ack := b == I2C_SNIFF_ACK
+                if !cur.addressed {
+                    cur.addressed = true
+                    cur.Addr = data >> 1
+                    cur.Read = data & I2C_READ_BIT == I2C_READ_BIT
+                    cur.AddrACK = ack
+                } else {
+                    cur.Data = append(cur.Data, I2CSniffByte{Data: data, ACK: ack})
+                }
+            default:
+                log.Printf("Sniff unexpected byte: %02X\n", b)
+        }
+    }
+} //sniff()
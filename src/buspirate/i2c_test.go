@@ -0,0 +1,636 @@
+package buspirate
+
+import (
+    "context"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "sync"
+    "testing"
+)
+
+// newEmulatedI2C returns an I2C mode BP on an Emulator with the given
+// devices on its bus.
+func newEmulatedI2C(t *testing.T, devs ...SimI2CDevice) (*I2C, *Emulator) {
+
+    nbp, em := newEmulatedBP(t)
+    for _, dev := range devs {
+        em.AttachI2C(dev)
+    }
+
+    i2c, err := nbp.ModeI2C()
+    if err != nil {
+        t.Fatalf("ModeI2C failed: %s", err)
+    }
+
+    return i2c, em
+} //newEmulatedI2C()
+
+// simMux is a TCA9548A, every byte written to it sets the channels.
+type simMux struct {
+    addr uint8
+    channels uint8
+}
+
+func (m *simMux) Address() uint8 {
+    return m.addr
+} //Address()
+
+func (m *simMux) OnStart(read bool) {
+} //OnStart()
+
+func (m *simMux) OnWrite(b uint8) bool {
+    m.channels = b
+    return true
+} //OnWrite()
+
+func (m *simMux) OnRead() uint8 {
+    return m.channels
+} //OnRead()
+
+func (m *simMux) OnStop() {
+} //OnStop()
+
+func TestI2CScan (t *testing.T) {
+
+    mpu := NewSimRegisters(0x68, 1, 256)
+    mpu.Regs[0x75] = 0x68
+    // A BME280 could be at 0x76, but so could a mux, so nothing's written.
+    mux := &simMux{addr: 0x76}
+    i2c, em := newEmulatedI2C(t, NewSim24C02(0x50), NewSimPCA9685(0x40), mpu, mux)
+
+    res, err := i2c.Scan(context.Background(), ScanOptions{Identify: true})
+    if err != nil {
+        t.Fatalf("Scan failed: %s", err)
+    }
+    expected := []ScanResult{
+        {Addr7: 0x40, ReadAck: true, WriteAck: true, Device: "PCA9685"},
+        {Addr7: 0x50, ReadAck: true, WriteAck: true, Device: "24Cxx EEPROM"},
+        {Addr7: 0x68, ReadAck: true, WriteAck: true, Device: "MPU-6050", Confirmed: true},
+        {Addr7: 0x76, ReadAck: true, WriteAck: true, Device: "TCA9548A"},
+    }
+    if len(res) != len(expected) {
+        t.Fatalf("Expected scan %+v, got %+v", expected, res)
+    }
+    for k, v := range expected {
+        if res[k] != v {
+            t.Fatalf("Expected scan %+v, got %+v", expected, res)
+        }
+    }
+    if mux.channels != 0 {
+        t.Fatalf("Scan switched on mux channels %X", mux.channels)
+    }
+
+    res, err = i2c.Scan(context.Background(),
+        ScanOptions{Range: true, First: 0x40, Last: 0x5F, Skip: []uint8{0x40}, WriteOnly: true})
+    if err != nil || len(res) != 1 || res[0] != (ScanResult{Addr7: 0x50, WriteAck: true}) {
+        t.Fatalf("Expected only a writer at 0x50, got %+v, %v", res, err)
+    }
+
+    // Reserved addresses, 0x00 included, are only probed when asked for.
+    em.AttachI2C(NewSimRegisters(0x00, 1, 1))
+    if res, err := i2c.Scan(context.Background(), ScanOptions{}); err != nil || res[0].Addr7 != 0x40 {
+        t.Fatalf("Expected 0x40 first, got %+v, %v", res, err)
+    }
+    res, err = i2c.Scan(context.Background(), ScanOptions{Range: true, First: 0x00, Last: 0x07})
+    if err != nil || len(res) != 1 || res[0].Addr7 != 0x00 {
+        t.Fatalf("Expected only 0x00, got %+v, %v", res, err)
+    }
+
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    if _, err := i2c.Scan(ctx, ScanOptions{}); err != context.Canceled {
+        t.Fatalf("Expected context.Canceled, got: %v", err)
+    }
+} //TestI2CScan()
+
+func TestI2CSendBytesTo (t *testing.T) {
+
+    eeprom := NewSim24C02(0x50)
+    i2c, _ := newEmulatedI2C(t, eeprom)
+
+    if _, err := i2c.Start(); err != nil {
+        t.Fatalf("Start failed: %s", err)
+    }
+    acks, err := i2c.SendBytesTo(0xA0, []uint8{0x10, 0xDE, 0xAD})
+    if err != nil {
+        t.Fatalf("SendBytesTo failed: %s", err)
+    }
+    if string(acks) != "\x00\x00\x00\x00" {
+        t.Fatalf("Expected every byte ACKed, got %q", acks)
+    }
+    if _, err := i2c.Stop(0xA0); err != nil {
+        t.Fatalf("Stop failed: %s", err)
+    }
+
+    if eeprom.Regs[0x10] != 0xDE || eeprom.Regs[0x11] != 0xAD {
+        t.Fatalf("EEPROM not written: %X", eeprom.Regs[0x10:0x12])
+    }
+
+    // Set the pointer back and read the bytes with a repeated START.
+    i2c.Start()
+    i2c.SendBytesTo(0xA0, []uint8{0x10})
+    i2c.Start()
+    acks, err = i2c.SendBytesTo(0xA1, nil)
+    if err != nil || acks[0] != 0x00 {
+        t.Fatalf("Read address not ACKed: %q, %s", acks, err)
+    }
+    b, err := i2c.ReadByte()
+    if err != nil || b != 0xDE {
+        t.Fatalf("Expected 0xDE, got %X, %s", b, err)
+    }
+    i2c.ACK()
+    b, err = i2c.ReadByte()
+    if err != nil || b != 0xAD {
+        t.Fatalf("Expected 0xAD, got %X, %s", b, err)
+    }
+    i2c.NACK()
+    i2c.Stop(0xA1)
+} //TestI2CSendBytesTo()
+
+func TestI2CNACK (t *testing.T) {
+
+    i2c, _ := newEmulatedI2C(t, NewSim24C02(0x50))
+
+    i2c.Start()
+    acks, err := i2c.SendBytesTo(0xA2, []uint8{0x00})
+    if err != nil {
+        t.Fatalf("SendBytesTo failed: %s", err)
+    }
+    if string(acks) != "\x01\x01" {
+        t.Fatalf("Expected the address and data NACKed, got %q", acks)
+    }
+    i2c.Stop(0xA2)
+
+    _, err = i2c.ReadFrom(0x51, 1)
+    var nack *ErrNACK
+    if !errors.As(err, &nack) || nack.Addr != 0x51 {
+        t.Fatalf("Expected ErrNACK for 0x51, got: %v", err)
+    }
+} //TestI2CNACK()
+
+func TestSimPCA9685 (t *testing.T) {
+
+    pca := NewSimPCA9685(0x40)
+    i2c, _ := newEmulatedI2C(t, pca)
+
+    // Auto-increment on, then write LED0 ON/OFF in one go.
+    i2c.Start()
+    i2c.SendBytesTo(0x80, []uint8{SIM_PCA9685_MODE1, SIM_PCA9685_MODE1_AI})
+    i2c.Stop(0x80)
+    i2c.Start()
+    i2c.SendBytesTo(0x80, []uint8{SIM_PCA9685_LED0, 0x00, 0x00, 0xFF, 0x0F})
+    i2c.Stop(0x80)
+
+    on, off := pca.Channel(0)
+    if on != 0 || off != 0x0FFF {
+        t.Fatalf("Expected LED0 0/4095, got %d/%d", on, off)
+    }
+
+    // PRE_SCALE is ignored unless the chip is asleep.
+    i2c.Start()
+    i2c.SendBytesTo(0x80, []uint8{SIM_PCA9685_PRE_SCALE, 0x79})
+    i2c.Stop(0x80)
+    if pca.Regs[SIM_PCA9685_PRE_SCALE] != 0x1E {
+        t.Fatalf("PRE_SCALE written while awake: %X", pca.Regs[SIM_PCA9685_PRE_SCALE])
+    }
+} //TestSimPCA9685()
+
+func TestI2CConcurrent (t *testing.T) {
+
+    i2c, _ := newEmulatedI2C(t, NewSim24C02(0x50))
+
+    var wg sync.WaitGroup
+    errs := make(chan error, 8)
+
+    // Each goroutine writes and reads back its own EEPROM address.
+    for g := 0; g < 4; g++ {
+        wg.Add(1)
+        go func(reg uint8) {
+            defer wg.Done()
+            for i := 0; i < 10; i++ {
+                val := reg + uint8(i)
+                var got uint8
+                err := i2c.Transaction(func(tx *I2C) error {
+                    tx.Start()
+                    if _, err := tx.SendBytesTo(0xA0, []uint8{reg, val}); err != nil {
+                        return err
+                    }
+                    tx.Stop(0xA0)
+
+                    tx.Start()
+                    tx.SendBytesTo(0xA0, []uint8{reg})
+                    tx.Start()
+                    tx.SendBytesTo(0xA1, nil)
+                    b, err := tx.ReadByte()
+                    got = b
+                    tx.NACK()
+                    tx.Stop(0xA1)
+                    return err
+                })
+                if err == nil && got != val {
+                    err = fmt.Errorf("register %X: wrote %X, read %X", reg, val, got)
+                }
+                if err != nil {
+                    errs <- err
+                    return
+                }
+            }
+        }(uint8(g * 0x10))
+    }
+
+    // Meanwhile, single commands from another goroutine.
+    wg.Add(1)
+    go func() {
+        defer wg.Done()
+        for i := 0; i < 20; i++ {
+            mode, err := i2c.Bp.GetMode()
+            if err == nil && mode != MODE_I2C_REPLY {
+                err = fmt.Errorf("GetMode got %q", mode)
+            }
+            if err != nil {
+                errs <- err
+                return
+            }
+        }
+    }()
+
+    wg.Wait()
+    close(errs)
+    for err := range errs {
+        t.Fatalf("Concurrent use failed: %s", err)
+    }
+} //TestI2CConcurrent()
+
+func TestI2CTx (t *testing.T) {
+
+    eeprom := NewSim24C02(0x50)
+    i2c, _ := newEmulatedI2C(t, eeprom)
+
+    // All 256 bytes in one go, well past the 16 byte bulk write.
+    w := []uint8{0x00}
+    for i := 0; i < 256; i++ {
+        w = append(w, uint8(255 - i))
+    }
+    if err := i2c.Tx(0x50, w, nil); err != nil {
+        t.Fatalf("Tx write failed: %s", err)
+    }
+    if eeprom.Regs[0x00] != 0xFF || eeprom.Regs[0xFF] != 0x00 {
+        t.Fatalf("EEPROM not written: %X", eeprom.Regs)
+    }
+
+    r := make([]uint8, 256)
+    if err := i2c.Tx(0x50, []uint8{0x00}, r); err != nil {
+        t.Fatalf("Tx read failed: %s", err)
+    }
+    if string(r) != string(w[1:]) {
+        t.Fatalf("Expected the EEPROM back, got %X", r)
+    }
+
+    err := i2c.Tx(0x51, []uint8{0x00}, r)
+    var nack *ErrNACK
+    if !errors.As(err, &nack) || nack.Addr != 0x51 {
+        t.Fatalf("Expected ErrNACK for 0x51, got: %v", err)
+    }
+
+    // The address byte takes one of WriteThenRead's 4096.
+    if err := i2c.Tx(0x50, make([]uint8, I2C_WRITE_READ_MAX), nil); err == nil {
+        t.Fatalf("Expected an error for a %d byte write", I2C_WRITE_READ_MAX)
+    }
+    if err := i2c.Tx(0x50, []uint8{0x00}, make([]uint8, I2C_WRITE_READ_MAX + 1)); err == nil {
+        t.Fatalf("Expected an error for a %d byte read", I2C_WRITE_READ_MAX + 1)
+    }
+} //TestI2CTx()
+
+// recordTransport keeps a copy of everything written to it.
+type recordTransport struct {
+    Transport
+    mu sync.Mutex
+    sent []uint8
+}
+
+func (rt *recordTransport) Write(p []uint8) (int, error) {
+    rt.mu.Lock()
+    rt.sent = append(rt.sent, p...)
+    rt.mu.Unlock()
+    return rt.Transport.Write(p)
+} //Write()
+
+func TestI2CReadFrom (t *testing.T) {
+
+    eeprom := NewSim24C02(0x50)
+    copy(eeprom.Regs[0x10:], []uint8{0xDE, 0xAD, 0xBE, 0xEF})
+
+    em := NewEmulator()
+    em.AttachI2C(eeprom)
+    rec := &recordTransport{Transport: em.Transport()}
+    nbp := NewBPWithTransport(rec)
+    if err := nbp.Init(); err != nil {
+        t.Fatalf("Init failed: %s", err)
+    }
+    t.Cleanup(func() { nbp.Close() })
+    i2c, err := nbp.ModeI2C()
+    if err != nil {
+        t.Fatalf("ModeI2C failed: %s", err)
+    }
+
+    // Point at 0x10, then read from there.
+    if err := i2c.Tx(0x50, []uint8{0x10}, nil); err != nil {
+        t.Fatalf("Tx failed: %s", err)
+    }
+    res, err := i2c.ReadFrom(0x50, 4)
+    if err != nil {
+        t.Fatalf("ReadFrom failed: %s", err)
+    }
+    if string(res) != "\xDE\xAD\xBE\xEF" {
+        t.Fatalf("Expected DEADBEEF, got %X", res)
+    }
+
+    // Every read ACKed but the last, then a STOP.
+    expected := []uint8{I2C_SEND_START, I2C_BULK_SEND, 0xA1,
+        I2C_READ_BYTE, I2C_SEND_ACK, I2C_READ_BYTE, I2C_SEND_ACK,
+        I2C_READ_BYTE, I2C_SEND_ACK, I2C_READ_BYTE, I2C_SEND_NACK,
+        I2C_SEND_STOP}
+    rec.mu.Lock()
+    sent := rec.sent[len(rec.sent) - len(expected):]
+    rec.mu.Unlock()
+    if string(sent) != string(expected) {
+        t.Fatalf("Expected the commands %X, sent %X", expected, sent)
+    }
+
+    // The pointer moved on past what was read.
+    res, err = i2c.ReadFrom(0x50, 1)
+    if err != nil || len(res) != 1 || res[0] != 0xFF {
+        t.Fatalf("Expected FF, got %X, %v", res, err)
+    }
+} //TestI2CReadFrom()
+
+func TestI2CRegs (t *testing.T) {
+
+    eeprom := NewSim24C02(0x50)
+    big := NewSim24C32(0x51)
+    pca := NewSimPCA9685(0x40)
+    i2c, _ := newEmulatedI2C(t, eeprom, big, pca)
+
+    if err := i2c.WriteReg8(0x50, 0x20, 0x5A); err != nil {
+        t.Fatalf("WriteReg8 failed: %s", err)
+    }
+    if v, err := i2c.ReadReg8(0x50, 0x20); err != nil || v != 0x5A {
+        t.Fatalf("ReadReg8 expected 5A, got %X, %v", v, err)
+    }
+
+    if err := i2c.WriteReg16(0x50, 0x30, 0x1234, binary.BigEndian); err != nil {
+        t.Fatalf("WriteReg16 failed: %s", err)
+    }
+    if eeprom.Regs[0x30] != 0x12 || eeprom.Regs[0x31] != 0x34 {
+        t.Fatalf("Expected 12 34 big endian, got %X", eeprom.Regs[0x30:0x32])
+    }
+    if v, err := i2c.ReadReg16(0x50, 0x30, binary.LittleEndian); err != nil || v != 0x3412 {
+        t.Fatalf("ReadReg16 expected 3412, got %X, %v", v, err)
+    }
+
+    // Past the 16 byte bulk write, and across a 16-bit register address.
+    data := []uint8("0123456789abcdefghij")
+    if err := i2c.WriteRegsAddr16(0x51, 0x0FF0, data); err != nil {
+        t.Fatalf("WriteRegsAddr16 failed: %s", err)
+    }
+    buf := make([]uint8, len(data))
+    if err := i2c.ReadRegsAddr16(0x51, 0x0FF0, buf); err != nil || string(buf) != string(data) {
+        t.Fatalf("ReadRegsAddr16 expected %q, got %q, %v", data, buf, err)
+    }
+
+    // Set auto-increment in MODE1 and leave SLEEP and ALLCALL alone.
+    err := i2c.UpdateBits(0x40, SIM_PCA9685_MODE1, SIM_PCA9685_MODE1_AI, 0xFF)
+    if err != nil {
+        t.Fatalf("UpdateBits failed: %s", err)
+    }
+    if pca.Regs[SIM_PCA9685_MODE1] != 0x31 {
+        t.Fatalf("Expected MODE1 31, got %X", pca.Regs[SIM_PCA9685_MODE1])
+    }
+
+    _, err = i2c.ReadReg8(0x52, 0x00)
+    var nack *ErrNACK
+    if !errors.As(err, &nack) || nack.Addr != 0x52 {
+        t.Fatalf("Expected ErrNACK for 0x52, got: %v", err)
+    }
+} //TestI2CRegs()
+
+func TestI2C10Bit (t *testing.T) {
+
+    eeprom := NewSim24C02(0x50)
+    i2c, _ := newEmulatedI2C(t, NewSimTenBit(0x2A5, eeprom), NewSim24C02(0x50))
+
+    if err := i2c.Tx10(0x2A5, []uint8{0x10, 'h', 'i'}, nil); err != nil {
+        t.Fatalf("Tx10 write failed: %s", err)
+    }
+    if string(eeprom.Regs[0x10:0x12]) != "hi" {
+        t.Fatalf("Expected hi written, got %q", eeprom.Regs[0x10:0x12])
+    }
+
+    r := make([]uint8, 2)
+    if err := i2c.Tx10(0x2A5, []uint8{0x10}, r); err != nil || string(r) != "hi" {
+        t.Fatalf("Tx10 expected hi, got %q, %v", r, err)
+    }
+    // Carries on from the register pointer.
+    eeprom.Regs[0x12] = '!'
+    if res, err := i2c.ReadFrom10(0x2A5, 1); err != nil || res[0] != '!' {
+        t.Fatalf("ReadFrom10 expected !, got %q, %v", res, err)
+    }
+
+    // The 7-bit 0x50 is a different device.
+    if res, err := i2c.ReadFrom(0x50, 1); err != nil || res[0] != 0xFF {
+        t.Fatalf("Expected FF from 7-bit 0x50, got %X, %v", res, err)
+    }
+
+    _, err := i2c.ReadFrom10(0x2A6, 1)
+    var nack *ErrNACK
+    if !errors.As(err, &nack) || nack.Addr != 0x2A6 || !nack.TenBit {
+        t.Fatalf("Expected ErrNACK for 0x2A6, got: %v", err)
+    }
+
+    found, err := i2c.Scan(context.Background(),
+        ScanOptions{Range: true, First: 0x50, Last: 0x50, TenBit: true, First10: 0x2A0, Last10: 0x2AF})
+    expected := []ScanResult{
+        {Addr7: 0x50, ReadAck: true, WriteAck: true},
+        {TenBit: true, Addr10: 0x2A5, ReadAck: true, WriteAck: true},
+    }
+    if err != nil || len(found) != 2 || found[0] != expected[0] || found[1] != expected[1] {
+        t.Fatalf("Scan expected %+v, got %+v, %v", expected, found, err)
+    }
+    found, err = i2c.Scan(context.Background(), ScanOptions{Range: true, First: 0x50, Last: 0x50,
+        TenBit: true, First10: 0x2A0, Last10: 0x2AF, Skip10: []uint16{0x2A5}})
+    if err != nil || len(found) != 1 {
+        t.Fatalf("Expected 0x2A5 skipped, got %+v, %v", found, err)
+    }
+} //TestI2C10Bit()
+
+// A combined 10-bit read is S, address, w, Sr, then only the first address
+// byte with the read bit.
+func TestI2CTx10Bus (t *testing.T) {
+
+    em := NewEmulator()
+    em.AttachI2C(NewSimTenBit(0x2A5, NewSim24C02(0x50)))
+    rec := &recordTransport{Transport: em.Transport()}
+    nbp := NewBPWithTransport(rec)
+    if err := nbp.Init(); err != nil {
+        t.Fatalf("Init failed: %s", err)
+    }
+    t.Cleanup(func() { nbp.Close() })
+    i2c, err := nbp.ModeI2C()
+    if err != nil {
+        t.Fatalf("ModeI2C failed: %s", err)
+    }
+
+    rec.mu.Lock()
+    rec.sent = nil
+    rec.mu.Unlock()
+
+    r := make([]uint8, 1)
+    if err := i2c.Tx10(0x2A5, []uint8{0x10}, r); err != nil {
+        t.Fatalf("Tx10 failed: %s", err)
+    }
+
+    rec.mu.Lock()
+    sent := rec.sent
+    rec.mu.Unlock()
+    want := []uint8{
+        I2C_SEND_START, I2C_BULK_SEND | 2, 0xF4, 0xA5, 0x10,
+        I2C_SEND_START, I2C_BULK_SEND, 0xF5,
+        I2C_READ_BYTE, I2C_SEND_NACK, I2C_SEND_STOP,
+    }
+    if string(sent) != string(want) {
+        t.Fatalf("Expected %X on the bus, sent %X", want, sent)
+    }
+} //TestI2CTx10Bus()
+
+func TestI2CSniff (t *testing.T) {
+
+    i2c, em := newEmulatedI2C(t, NewSim24C02(0x50))
+
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+
+    txs, err := i2c.Sniff(ctx)
+    if err != nil {
+        t.Fatalf("Sniff failed: %s", err)
+    }
+    if em.Mode() != EMU_I2C_SNIFF {
+        t.Fatalf("Expected sniffer mode, got %d", em.Mode())
+    }
+
+    // Write register 0x10 of 0x50, repeated START, read two bytes, STOP;
+    // then a NACKed address. The data includes the escaped special bytes.
+    em.I2CSniffSend([]uint8("[\\\xA0+\\\x10+[\\\xA1+\\[+\\]-][\\\x42-]"))
+
+    tx := <-txs
+    if tx.Addr != 0x50 || tx.Read || !tx.AddrACK || tx.Repeated || tx.Stopped ||
+            len(tx.Data) != 1 || tx.Data[0] != (I2CSniffByte{0x10, true}) {
+        t.Fatalf("Unexpected write: %+v", tx)
+    }
+
+    tx = <-txs
+    want := []I2CSniffByte{{'[', true}, {']', false}}
+    if tx.Addr != 0x50 || !tx.Read || !tx.Repeated || !tx.Stopped ||
+            len(tx.Data) != 2 || tx.Data[0] != want[0] || tx.Data[1] != want[1] {
+        t.Fatalf("Unexpected read: %+v", tx)
+    }
+    if tx.Start.IsZero() || tx.End.Before(tx.Start) {
+        t.Fatalf("Bad timestamps: %s - %s", tx.Start, tx.End)
+    }
+
+    tx = <-txs
+    if tx.Addr != 0x21 || tx.AddrACK || len(tx.Data) != 0 {
+        t.Fatalf("Unexpected NACK: %+v", tx)
+    }
+
+    cancel()
+    for range txs {
+    }
+
+    // Back in I2C mode, and the BP is free again.
+    if em.Mode() != EMU_I2C {
+        t.Fatalf("Expected I2C mode, got %d", em.Mode())
+    }
+    if _, err := i2c.ReadFrom(0x50, 1); err != nil {
+        t.Fatalf("ReadFrom after Sniff failed: %s", err)
+    }
+} //TestI2CSniff()
+
+func TestI2CSpeed (t *testing.T) {
+
+    i2c, em := newEmulatedI2C(t)
+
+    if i2c.Speed() != I2C_SPEED_5 {
+        t.Fatalf("Expected the default speed 5kHz, got %s", i2c.Speed())
+    }
+    err := i2c.SetSpeed(I2C_SPEED_100)
+    if speed := em.I2CSpeed(); err != nil || speed != I2C_SPEED_100 {
+        t.Fatalf("SetSpeed failed: %v, emulator at %d", err, speed)
+    }
+    if err := i2c.SetSpeed(0x04); err == nil {
+        t.Fatalf("Expected an error for an invalid speed")
+    }
+
+    speed, err := i2c.SetSpeedHz(300000)
+    if err != nil || speed != I2C_SPEED_400 || i2c.Speed() != I2C_SPEED_400 {
+        t.Fatalf("SetSpeedHz expected 400kHz, got %s, %v", speed, err)
+    }
+    if speed, _ := i2c.SetSpeedHz(20000); speed != I2C_SPEED_5 {
+        t.Fatalf("SetSpeedHz expected 5kHz, got %s", speed)
+    }
+    // Halfway rounds down, every time.
+    for i := 0; i < 20; i++ {
+        if speed, _ := i2c.SetSpeedHz(75000); speed != I2C_SPEED_50 {
+            t.Fatalf("SetSpeedHz expected 50kHz, got %s", speed)
+        }
+    }
+    if _, err := i2c.SetSpeedHz(60000); err != nil {
+        t.Fatalf("SetSpeedHz failed: %s", err)
+    }
+
+    // Re-entering I2C mode puts the speed back.
+    i2c, err = i2c.Bp.ModeI2C()
+    if err != nil {
+        t.Fatalf("ModeI2C failed: %s", err)
+    }
+    if speed := em.I2CSpeed(); i2c.Speed() != I2C_SPEED_50 || speed != I2C_SPEED_50 {
+        t.Fatalf("Expected 50kHz kept, got %s, emulator at %d", i2c.Speed(), speed)
+    }
+} //TestI2CSpeed()
+
+func TestI2CPeripherals (t *testing.T) {
+
+    i2c, em := newEmulatedI2C(t)
+
+    if err := i2c.Power(true); err != nil {
+        t.Fatalf("Power failed: %s", err)
+    }
+    if err := i2c.Pullups(true); err != nil {
+        t.Fatalf("Pullups failed: %s", err)
+    }
+    if periph := em.I2CPeripherals(); periph != PERIPH_POWER | PERIPH_PULLUPS {
+        t.Fatalf("Expected power and pullups on, got %X", periph)
+    }
+    if p := i2c.Peripherals(); p != (Peripherals{Power: true, Pullups: true}) {
+        t.Fatalf("Unexpected peripherals: %+v", p)
+    }
+
+    if err := i2c.ConfigurePeripherals(Peripherals{AUX: true, CS: true}); err != nil {
+        t.Fatalf("ConfigurePeripherals failed: %s", err)
+    }
+    if periph := em.I2CPeripherals(); periph != PERIPH_AUX | PERIPH_CS {
+        t.Fatalf("Expected AUX and CS on, got %X", periph)
+    }
+
+    // Each mode has its own.
+    spi, err := i2c.Bp.ModeSPI()
+    if err != nil {
+        t.Fatalf("ModeSPI failed: %s", err)
+    }
+    if p := spi.Peripherals(); p != (Peripherals{}) {
+        t.Fatalf("Expected SPI peripherals off, got %+v", p)
+    }
+    if err := i2c.Power(true); err != ErrNotInMode {
+        t.Fatalf("Expected ErrNotInMode, got: %v", err)
+    }
+} //TestI2CPeripherals()
@@ -0,0 +1,167 @@
+package buspirate
+
+import (
+    "context"
+    "errors"
+    "log"
+    "time"
+)
+
+const (
+    // VOLT_STREAM starts continuous ADC samples, 2 bytes each MSB first,
+    // until any byte is sent.
+    VOLT_STREAM = 0x15
+    // The ADC pin has a 1/2 divider in front of a 10-bit, 3.3V ADC.
+    ADC_VOLTS = 6.6 / 1024
+    // FREQ_MEASURE counts edges on AUX and replies with the frequency in Hz,
+    // 4 bytes MSB first.
+    FREQ_MEASURE = 0x16
+    // The count takes up to a second, longer at low frequencies.
+    FREQ_TIMEOUT = 2000
+)
+
+// adcVolts converts a 2 byte ADC reading to volts.
+func adcVolts(hi, lo uint8) float64 {
+    return float64(uint16(hi) << 8 | uint16(lo)) * ADC_VOLTS
+} //adcVolts()
+
+// MeasureVoltage reads the voltage on the ADC pin, in bitbang mode.
+func (bp *BP) MeasureVoltage() (float64, error) {
+
+    bp, unlock := bp.acquire()
+    defer unlock()
+
+    if err := bp.checkMode(MODE_BITBANG); err != nil {
+        return 0, err
+    }
+
+    res, err := bp.writeReadN([]uint8{VOLT_MEASURE}, 2)
+    if err != nil {
+        return 0, err
+    }
+
+    return adcVolts(res[0], res[1]), nil
+} //MeasureVoltage()
+
+// VoltageSample is one reading from StreamVoltage. Time is when it arrived.
+type VoltageSample struct {
+    Time time.Time
+    Volts float64
+}
+
+// StreamVoltage puts the Bus Pirate in continuous ADC mode and sends the
+// samples, as fast as it makes them, on the returned channel. The BP is held
+// until ctx is done, then it's back in bitbang mode and the channel is
+// closed. Samples not taken off the channel in time are dropped.
+func (bp *BP) StreamVoltage(ctx context.Context) (<-chan VoltageSample, error) {
+
+    bp, unlock := bp.acquire()
+
+    if err := bp.checkMode(MODE_BITBANG); err != nil {
+        unlock()
+        return nil, err
+    }
+
+    _, err := bp.write([]uint8{VOLT_STREAM})
+    if err != nil {
+        unlock()
+        return nil, err
+    }
+
+    ch := make(chan VoltageSample, 64)
+    go func() {
+        defer close(ch)
+        defer unlock()
+
+        for {
+            res, err := bp.ReadExactly(ctx, 2)
+            if err != nil {
+                if ctx.Err() == nil {
+                    log.Printf("StreamVoltage stopped: %s\n", err)
+                }
+                break
+            }
+
+            select {
+                case ch <- VoltageSample{Time: time.Now(), Volts: adcVolts(res[0], res[1])}:
+                default:
+            }
+        }
+
+        // Any byte stops the stream. If it isn't swallowed, BINARY_RESET
+        // just gets a BBIO1 reply, which is drained with the last samples.
+        _, err := bp.write([]uint8{BINARY_RESET})
+        if err == nil {
+            bp.drain()
+        }
+    }()
+
+    return ch, nil
+} //StreamVoltage()
+
+// MeasureFrequency measures the frequency on the AUX pin, in bitbang mode.
+func (bp *BP) MeasureFrequency() (float64, error) {
+
+    bp, unlock := bp.acquire()
+    defer unlock()
+
+    if err := bp.checkMode(MODE_BITBANG); err != nil {
+        return 0, err
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(),
+        FREQ_TIMEOUT * time.Millisecond)
+    defer cancel()
+
+    res, err := bp.writeReadCtx(ctx, []uint8{FREQ_MEASURE}, 4)
+    if err != nil {
+        return 0, err
+    }
+
+    hz := uint32(res[0]) << 24 | uint32(res[1]) << 16 | uint32(res[2]) << 8 | uint32(res[3])
+    return float64(hz), nil
+} //MeasureFrequency()
+
+// FrequencyStats summarizes repeated frequency measurements, in Hz.
+type FrequencyStats struct {
+    Samples []float64
+    Min float64
+    Max float64
+    Mean float64
+}
+
+// MeasureFrequencyN takes n frequency measurements, interval apart, and
+// returns them with their min, max and mean. On an error the samples taken
+// so far are returned.
+func (bp *BP) MeasureFrequencyN(n int, interval time.Duration) (FrequencyStats, error) {
+
+    var stats FrequencyStats
+    if n < 1 {
+        return stats, errors.New("Must take at least 1 sample")
+    }
+
+    sum := 0.0
+
+    for i := 0; i < n; i++ {
+        if i > 0 {
+            time.Sleep(interval)
+        }
+
+        hz, err := bp.MeasureFrequency()
+        if err != nil {
+            return stats, err
+        }
+
+        if len(stats.Samples) == 0 || hz < stats.Min {
+            stats.Min = hz
+        }
+        if len(stats.Samples) == 0 || hz > stats.Max {
+            stats.Max = hz
+        }
+        stats.Samples = append(stats.Samples, hz)
+        sum += hz
+        stats.Mean = sum / float64(len(stats.Samples))
+    }
+
+    return stats, nil
+} //MeasureFrequencyN()
@@ -0,0 +1,327 @@
+package buspirate
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+)
+
+const (
+    // http://dangerousprototypes.com/2009/10/20/bus-pirate-binary-1-wire-mode/
+    // 00000000 – Reset to bitbang mode, responds “BBIOx”
+    // 00000001 – Mode version string (1W01)
+    // 00000010 – 1-Wire reset
+    // 00000100 – Read byte
+    // 00001000 – ROM search macro (0xF0)
+    // 00001001 – ALARM search macro (0xEC)
+    // 0001xxxx – Bulk 1-Wire write, send 1-16 bytes (0=1byte!)
+    // 0100wxyz – Configure peripherals w=power, x=pullups, y=AUX, z=CS
+
+    ONEWIRE_RESET = 0x02
+    ONEWIRE_READ_BYTE = 0x04
+    ONEWIRE_ROM_SEARCH = 0x08
+    ONEWIRE_ALARM_SEARCH = 0x09
+    ONEWIRE_BULK_WRITE = 0x10
+    ONEWIRE_BULK_MAX = 16
+
+    // ROM commands
+    ONEWIRE_CMD_SEARCH_ROM = 0xF0
+    ONEWIRE_CMD_READ_ROM = 0x33
+    ONEWIRE_CMD_MATCH_ROM = 0x55
+    ONEWIRE_CMD_SKIP_ROM = 0xCC
+    ONEWIRE_CMD_ALARM_SEARCH = 0xEC
+)
+
+// ROMCode is a 64-bit 1-Wire ROM code, stored the way it comes off the
+// bus: family code in the low byte, then the 48-bit serial number, then the
+// CRC in the high byte.
+type ROMCode uint64
+
+// NewROMCode builds the ROM code for a family and 48-bit serial number,
+// including its CRC.
+func NewROMCode(family uint8, serial uint64) ROMCode {
+    rom := ROMCode(family) | ROMCode(serial & 0xFFFFFFFFFFFF) << 8
+    crc := OneWireCRC8(rom.Bytes()[:7])
+    return rom | ROMCode(crc) << 56
+} //NewROMCode()
+
+// ParseROMCode parses 8 bytes, in bus order, into a ROMCode and checks its
+// CRC.
+func ParseROMCode(b []uint8) (ROMCode, error) {
+
+    if len(b) != 8 {
+        return 0, errors.New(fmt.Sprintf("ROM code must be 8 bytes, got: %d", len(b)))
+    }
+
+    var rom ROMCode
+    for i := 7; i >= 0; i-- {
+        rom = rom << 8 | ROMCode(b[i])
+    }
+
+    if !rom.Valid() {
+        return rom, errors.New(fmt.Sprintf("Bad ROM code CRC: %s", rom))
+    }
+
+    return rom, nil
+} //ParseROMCode()
+
+// Bytes returns the ROM code in bus order.
+func (rom ROMCode) Bytes() []uint8 {
+    b := make([]uint8, 8)
+    for i := range b {
+        b[i] = uint8(rom >> (8 * uint(i)))
+    }
+    return b
+} //Bytes()
+
+func (rom ROMCode) Family() uint8 {
+    return uint8(rom)
+} //Family()
+
+func (rom ROMCode) Serial() uint64 {
+    return uint64(rom >> 8) & 0xFFFFFFFFFFFF
+} //Serial()
+
+func (rom ROMCode) CRC() uint8 {
+    return uint8(rom >> 56)
+} //CRC()
+
+// Valid reports whether the CRC byte matches the rest of the code.
+func (rom ROMCode) Valid() bool {
+    return OneWireCRC8(rom.Bytes()[:7]) == rom.CRC()
+} //Valid()
+
+// String formats the code the way Linux's w1 driver names devices, family
+// and serial: "28-00000a1b2c3d".
+func (rom ROMCode) String() string {
+    return fmt.Sprintf("%02x-%012x", rom.Family(), rom.Serial())
+} //String()
+
+// OneWireCRC8 is the Dallas/Maxim CRC-8 (x^8 + x^5 + x^4 + 1) used for ROM
+// codes and scratchpads.
+func OneWireCRC8(data []uint8) uint8 {
+
+    var crc uint8
+    for _, b := range data {
+        for i := 0; i < 8; i++ {
+            mix := (crc ^ b) & 0x01
+            crc >>= 1
+            if mix != 0 {
+                crc ^= 0x8C
+            }
+            b >>= 1
+        }
+    }
+
+    return crc
+} //OneWireCRC8()
+
+type OneWire struct {
+    Bp *BP
+}
+
+func NewOneWire(bp *BP) *OneWire {
+    return &OneWire{Bp: bp}
+} //NewOneWire()
+
+// acquire is BP.acquire for a OneWire handle.
+func (ow *OneWire) acquire() (*OneWire, func()) {
+    tx, unlock := ow.Bp.acquire()
+    return &OneWire{Bp: tx}, unlock
+} //acquire()
+
+// Transaction runs f with a handle that holds the BP, so nothing from other
+// goroutines gets in between its commands.
+func (ow *OneWire) Transaction(f func(tx *OneWire) error) error {
+
+    tx, unlock := ow.acquire()
+    defer unlock()
+
+    return f(tx)
+} //Transaction()
+
+// ConfigurePeripherals sets all of the 1-Wire mode peripherals at once.
+func (ow *OneWire) ConfigurePeripherals(p Peripherals) error {
+    return ow.Bp.configurePeripherals(MODE_1WIRE, p.bits())
+} //ConfigurePeripherals()
+
+// Peripherals returns the 1-Wire mode peripherals as last set.
+func (ow *OneWire) Peripherals() Peripherals {
+    return ow.Bp.peripherals(MODE_1WIRE)
+} //Peripherals()
+
+func (ow *OneWire) setPeriph(bit uint8, on bool) error {
+    return ow.Bp.setPeriph(MODE_1WIRE, bit, on)
+} //setPeriph()
+
+func (ow *OneWire) Power(on bool) error {
+    return ow.setPeriph(PERIPH_POWER, on)
+} //Power()
+
+func (ow *OneWire) Pullups(on bool) error {
+    return ow.setPeriph(PERIPH_PULLUPS, on)
+} //Pullups()
+
+func (ow *OneWire) AUX(on bool) error {
+    return ow.setPeriph(PERIPH_AUX, on)
+} //AUX()
+
+func (ow *OneWire) CS(on bool) error {
+    return ow.setPeriph(PERIPH_CS, on)
+} //CS()
+
+// Reset sends a bus reset. The Bus Pirate answers 0x01 whether or not any
+// device sent a presence pulse, so this can't tell if the bus is empty, use
+// Present for that.
+func (ow *OneWire) Reset() error {
+
+    bytes, err := ow.Bp.modeWriteRead(MODE_1WIRE, []uint8{ONEWIRE_RESET}, 1)
+    if err != nil {
+        return err
+    }
+    if bytes[0] != 0x01 {
+        return &ErrUnexpectedReply{Want: []uint8{0x01}, Got: bytes}
+    }
+
+    return nil
+} //Reset()
+
+func (ow *OneWire) ReadByte() (byte, error) {
+
+    bytes, err := ow.Bp.modeWriteRead(MODE_1WIRE, []uint8{ONEWIRE_READ_BYTE}, 1)
+    if err != nil {
+        return 0, err
+    }
+
+    return bytes[0], nil
+} //ReadByte()
+
+// ReadBytes reads n bytes.
+func (ow *OneWire) ReadBytes(n int) ([]uint8, error) {
+
+    res := make([]uint8, 0, n)
+    for len(res) < n {
+        b, err := ow.ReadByte()
+        if err != nil {
+            return res, err
+        }
+        res = append(res, b)
+    }
+
+    return res, nil
+} //ReadBytes()
+
+func (ow *OneWire) WriteByte(b byte) error {
+    return ow.WriteBytes([]uint8{b})
+} //WriteByte()
+
+// WriteBytes writes data in bulk writes of up to 16 bytes.
+func (ow *OneWire) WriteBytes(data []uint8) error {
+
+    for len(data) > 0 {
+        chunk := data
+        if len(chunk) > ONEWIRE_BULK_MAX {
+            chunk = chunk[:ONEWIRE_BULK_MAX]
+        }
+        data = data[len(chunk):]
+
+        sending := []uint8{ONEWIRE_BULK_WRITE | uint8(len(chunk)-1)}
+        sending = append(sending, chunk...)
+
+        // 0x01 for the command and for every byte written.
+        res, err := ow.Bp.modeWriteRead(MODE_1WIRE, sending, len(sending))
+        if err != nil {
+            return err
+        }
+        for _, r := range res {
+            if r != 0x01 {
+                return &ErrUnexpectedReply{Want: bytes.Repeat([]uint8{0x01}, len(sending)), Got: res}
+            }
+        }
+    }
+
+    return nil
+} //WriteBytes()
+
+// Select resets the bus and addresses a single device with MATCH ROM, or
+// every device with SKIP ROM when rom is 0. A missing device isn't noticed,
+// reads just come back 0xFF.
+func (ow *OneWire) Select(rom ROMCode) error {
+
+    ow, unlock := ow.acquire()
+    defer unlock()
+
+    err := ow.Reset()
+    if err != nil {
+        return err
+    }
+
+    if rom == 0 {
+        return ow.WriteByte(ONEWIRE_CMD_SKIP_ROM)
+    }
+
+    return ow.WriteBytes(append([]uint8{ONEWIRE_CMD_MATCH_ROM}, rom.Bytes()...))
+} //Select()
+
+// search runs one of the Bus Pirate's search macros. The reply is 0x01, then
+// 8 bytes per device found, then 8 bytes of 0xFF.
+func (ow *OneWire) search(cmd uint8) ([]ROMCode, error) {
+
+    ow, unlock := ow.acquire()
+    defer unlock()
+
+    bp := ow.Bp
+    if err := bp.checkMode(MODE_1WIRE); err != nil {
+        return nil, err
+    }
+
+    bytes, err := bp.writeReadN([]uint8{cmd}, 1)
+    if err != nil {
+        return nil, err
+    }
+    if bytes[0] != 0x01 {
+        return nil, &ErrUnexpectedReply{Want: []uint8{0x01}, Got: bytes}
+    }
+
+    var res []ROMCode
+    for {
+        // Each device found can take a while, give each its own deadline.
+        ctx, cancel := bp.readContext(8)
+        code, err := bp.ReadExactly(ctx, 8)
+        cancel()
+        if err != nil {
+            return res, err
+        }
+
+        if string(code) == "\xFF\xFF\xFF\xFF\xFF\xFF\xFF\xFF" {
+            return res, nil
+        }
+
+        rom, err := ParseROMCode(code)
+        if err != nil {
+            return res, err
+        }
+        res = append(res, rom)
+    }
+} //search()
+
+// Search returns the ROM codes of every device on the bus.
+func (ow *OneWire) Search() ([]ROMCode, error) {
+    return ow.search(ONEWIRE_ROM_SEARCH)
+} //Search()
+
+// Present reports whether any device is on the bus, by searching for one.
+func (ow *OneWire) Present() (bool, error) {
+
+    roms, err := ow.Search()
+    if err != nil {
+        return false, err
+    }
+
+    return len(roms) > 0, nil
+} //Present()
+
+// AlarmSearch returns the ROM codes of the devices with an alarm set.
+func (ow *OneWire) AlarmSearch() ([]ROMCode, error) {
+    return ow.search(ONEWIRE_ALARM_SEARCH)
+} //AlarmSearch()
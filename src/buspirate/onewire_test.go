@@ -0,0 +1,111 @@
+package buspirate
+
+import (
+    "testing"
+)
+
+func TestROMCode (t *testing.T) {
+
+    // ROM code from the DS18B20 datasheet's CRC example.
+    rom, err := ParseROMCode([]uint8{0x02, 0x1C, 0xB8, 0x01, 0x00, 0x00, 0x00, 0xA2})
+    if err != nil {
+        t.Fatalf("ParseROMCode failed: %s", err)
+    }
+    if rom.Family() != 0x02 || rom.Serial() != 0x01B81C || rom.CRC() != 0xA2 {
+        t.Fatalf("Bad ROM code fields: %s %X", rom, rom.CRC())
+    }
+    if NewROMCode(0x02, 0x01B81C) != rom {
+        t.Fatalf("NewROMCode mismatch: %X != %X", uint64(NewROMCode(0x02, 0x01B81C)), uint64(rom))
+    }
+
+    if _, err := ParseROMCode([]uint8{0x02, 0x1C, 0xB8, 0x01, 0x00, 0x00, 0x00, 0xA3}); err == nil {
+        t.Fatalf("Expected a CRC error")
+    }
+} //TestROMCode()
+
+func TestOneWireSearch (t *testing.T) {
+
+    a := NewSimDS18B20(0x0000056A1B2C, 21.5)
+    b := NewSimDS18B20(0x00000A000001, -10.125)
+    nbp, em := newEmulatedBP(t)
+    ow, err := nbp.ModeOneWire()
+    if err != nil {
+        t.Fatalf("ModeOneWire failed: %s", err)
+    }
+    if em.Mode() != EMU_ONEWIRE {
+        t.Fatalf("Expected 1-Wire mode, emulator in mode %d", em.Mode())
+    }
+
+    // Reset can't tell an empty bus, Present can.
+    if err := ow.Reset(); err != nil {
+        t.Fatalf("Reset failed: %s", err)
+    }
+    if present, err := ow.Present(); err != nil || present {
+        t.Fatalf("Expected nothing present, got %v, %v", present, err)
+    }
+
+    em.AttachOneWire(a)
+    em.AttachOneWire(b)
+    if present, err := ow.Present(); err != nil || !present {
+        t.Fatalf("Expected devices present, got %v, %v", present, err)
+    }
+
+    roms, err := ow.Search()
+    if err != nil {
+        t.Fatalf("Search failed: %s", err)
+    }
+    if len(roms) != 2 {
+        t.Fatalf("Expected 2 devices, got %v", roms)
+    }
+    for _, rom := range roms {
+        if rom != a.Rom && rom != b.Rom {
+            t.Fatalf("Unexpected ROM code %s", rom)
+        }
+    }
+} //TestOneWireSearch()
+
+func TestDS18B20 (t *testing.T) {
+
+    a := NewSimDS18B20(0x0000056A1B2C, 21.5)
+    b := NewSimDS18B20(0x00000A000001, -10.125)
+    nbp, em := newEmulatedBP(t)
+    ow, err := nbp.ModeOneWire()
+    if err != nil {
+        t.Fatalf("ModeOneWire failed: %s", err)
+    }
+    em.AttachOneWire(a)
+    em.AttachOneWire(b)
+
+    sensors, err := FindDS18B20(ow)
+    if err != nil || len(sensors) != 2 {
+        t.Fatalf("FindDS18B20 failed: %d, %s", len(sensors), err)
+    }
+
+    for _, ds := range sensors {
+        ds.ConvertTime = 0
+        temp, err := ds.Temperature()
+        if err != nil {
+            t.Fatalf("Temperature failed: %s", err)
+        }
+
+        expected := a.Temp
+        if ds.ROM == b.Rom {
+            expected = b.Temp
+        }
+        if temp != expected {
+            t.Fatalf("%s expected %f, got %f", ds.ROM, expected, temp)
+        }
+    }
+
+    // At 9 bits the sensor only has half degree steps.
+    ds := sensors[0]
+    if err := ds.SetResolution(9); err != nil {
+        t.Fatalf("SetResolution failed: %s", err)
+    }
+    a.Temp, b.Temp = 21.8, 21.8
+    ds.ConvertTime = 0
+    temp, err := ds.Temperature()
+    if err != nil || temp != 21.5 {
+        t.Fatalf("Expected 21.5 at 9 bits, got %f, %s", temp, err)
+    }
+} //TestDS18B20()
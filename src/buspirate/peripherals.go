@@ -0,0 +1,92 @@
+package buspirate
+
+import (
+    "log"
+)
+
+const (
+    // The 0100wxyz bits of SET_PERIPH, the same in every binary sub mode.
+    PERIPH_POWER = 0x08
+    PERIPH_PULLUPS = 0x04
+    PERIPH_AUX = 0x02
+    PERIPH_CS = 0x01
+)
+
+// Peripherals are the Bus Pirate's power supplies, pullup resistors, and AUX
+// and CS pins, as set with the 0100wxyz command of the binary sub modes.
+type Peripherals struct {
+    Power bool
+    Pullups bool
+    AUX bool
+    CS bool
+}
+
+// bits returns p as the wxyz bits of SET_PERIPH.
+func (p Peripherals) bits() uint8 {
+
+    var bits uint8
+    if p.Power {
+        bits |= PERIPH_POWER
+    }
+    if p.Pullups {
+        bits |= PERIPH_PULLUPS
+    }
+    if p.AUX {
+        bits |= PERIPH_AUX
+    }
+    if p.CS {
+        bits |= PERIPH_CS
+    }
+
+    return bits
+} //bits()
+
+func peripheralsOf(bits uint8) Peripherals {
+    return Peripherals{
+        Power: bits & PERIPH_POWER != 0,
+        Pullups: bits & PERIPH_PULLUPS != 0,
+        AUX: bits & PERIPH_AUX != 0,
+        CS: bits & PERIPH_CS != 0,
+    }
+} //peripheralsOf()
+
+// configurePeripherals sets the peripherals to bits, in mode, and remembers
+// them for that mode.
+func (bp *BP) configurePeripherals(mode uint8, bits uint8) error {
+
+    bp, unlock := bp.acquire()
+    defer unlock()
+
+    err := bp.modeWriteOK(mode, []uint8{SET_PERIPH | bits})
+    if err != nil {
+        log.Printf("Unable set peripherals: %x\n", bits)
+        return err
+    }
+
+    bp.periph[mode] = bits
+    return nil
+} //configurePeripherals()
+
+// setPeriph turns one peripheral bit on or off, in mode, leaving the others
+// as they were.
+func (bp *BP) setPeriph(mode uint8, bit uint8, on bool) error {
+
+    bp, unlock := bp.acquire()
+    defer unlock()
+
+    periph := bp.periph[mode] &^ bit
+    if on {
+        periph |= bit
+    }
+
+    return bp.configurePeripherals(mode, periph)
+} //setPeriph()
+
+// peripherals returns the peripherals last set in mode.
+func (bp *BP) peripherals(mode uint8) Peripherals {
+
+    bp, unlock := bp.acquire()
+    defer unlock()
+
+    return peripheralsOf(bp.periph[mode])
+} //peripherals()
@@ -0,0 +1,197 @@
+package buspirate
+
+import (
+    "errors"
+    "fmt"
+    "log"
+    "strings"
+)
+
+// Pin is one of the Bus Pirate's bitbang mode pins, or several ORed together.
+// The values are the bits of the SET_PINS_* commands and their replies.
+type Pin uint8
+
+const (
+    PIN_CS Pin = 0x01
+    PIN_MISO Pin = 0x02
+    PIN_CLK Pin = 0x04
+    PIN_MOSI Pin = 0x08
+    PIN_AUX Pin = 0x10
+    PIN_PULLUP Pin = 0x20
+    PIN_POWER Pin = 0x40
+
+    PINS_ALL = PIN_CS | PIN_MISO | PIN_CLK | PIN_MOSI | PIN_AUX | PIN_PULLUP | PIN_POWER
+    // The pins that can be inputs, POWER and PULLUP are always outputs.
+    PINS_IO = PIN_CS | PIN_MISO | PIN_CLK | PIN_MOSI | PIN_AUX
+)
+
+// Highest bit first, as the Bus Pirate labels them.
+var pinNames = []struct {
+    pin Pin
+    name string
+}{
+    {PIN_POWER, "POWER"},
+    {PIN_PULLUP, "PULLUP"},
+    {PIN_AUX, "AUX"},
+    {PIN_MOSI, "MOSI"},
+    {PIN_CLK, "CLK"},
+    {PIN_MISO, "MISO"},
+    {PIN_CS, "CS"},
+}
+
+func (p Pin) String() string {
+
+    var names []string
+    for _, pn := range pinNames {
+        if p & pn.pin != 0 {
+            names = append(names, pn.name)
+        }
+    }
+
+    return strings.Join(names, "|")
+} //String()
+
+// PinState is a snapshot of the pins as the Bus Pirate read them back: the
+// level being driven on outputs, the level seen on inputs.
+type PinState uint8
+
+// High reports whether all of pins are high.
+func (s PinState) High(pins Pin) bool {
+    return Pin(s) & pins == pins
+} //High()
+
+func (s PinState) String() string {
+
+    var levels []string
+    for _, pn := range pinNames {
+        level := 0
+        if s.High(pn.pin) {
+            level = 1
+        }
+        levels = append(levels, fmt.Sprintf("%s:%d", pn.name, level))
+    }
+
+    return strings.Join(levels, " ")
+} //String()
+
+// writePins sends a bitbang pin command, every one of which replies with the
+// pin states.
+func (bp *BP) writePins(cmd uint8) (PinState, error) {
+
+    if err := bp.checkMode(MODE_BITBANG); err != nil {
+        return 0, err
+    }
+
+    res, err := bp.writeReadN([]uint8{cmd}, 1)
+    if err != nil {
+        log.Printf("Unable to set pins: %x\n", cmd)
+        return 0, err
+    }
+
+    if cmd & SET_PINS_HIGH_LOW != 0 {
+        return PinState(res[0] & uint8(PINS_ALL)), nil
+    }
+
+    // The reply to SET_PINS_IN_OUT keeps its 010 prefix over POWER and
+    // PULLUP, which are outputs, so they're as last set.
+    outs := Pin(bp.pins_high_low) & (PIN_POWER | PIN_PULLUP)
+    return PinState(Pin(res[0]) & PINS_IO | outs), nil
+} //writePins()
+
+// setLevels drives the output pins to high_low, remembering it on success.
+func (bp *BP) setLevels(high_low Pin) (PinState, error) {
+
+    state, err := bp.writePins(SET_PINS_HIGH_LOW | uint8(high_low))
+    if err != nil {
+        return 0, err
+    }
+
+    bp.pins_high_low = uint8(high_low)
+    return state, nil
+} //setLevels()
+
+// setDirections makes the pins of in_out inputs and the rest outputs,
+// remembering it on success.
+func (bp *BP) setDirections(in_out Pin) (PinState, error) {
+
+    state, err := bp.writePins(SET_PINS_IN_OUT | uint8(in_out))
+    if err != nil {
+        return 0, err
+    }
+
+    bp.pins_in_out = uint8(in_out)
+    return state, nil
+} //setDirections()
+
+func checkPins(pins Pin, valid Pin) error {
+
+    if pins &^ valid != 0 {
+        return errors.New(fmt.Sprintf("Invalid pins: %02X", uint8(pins)))
+    }
+
+    return nil
+} //checkPins()
+
+// SetPinsHigh drives pins high, leaving the others as they were, and returns
+// the pin states read back.
+func (bp *BP) SetPinsHigh(pins Pin) (PinState, error) {
+
+    if err := checkPins(pins, PINS_ALL); err != nil {
+        return 0, err
+    }
+
+    bp, unlock := bp.acquire()
+    defer unlock()
+
+    return bp.setLevels(Pin(bp.pins_high_low) | pins)
+} //SetPinsHigh()
+
+// SetPinsLow drives pins low, leaving the others as they were, and returns
+// the pin states read back.
+func (bp *BP) SetPinsLow(pins Pin) (PinState, error) {
+
+    if err := checkPins(pins, PINS_ALL); err != nil {
+        return 0, err
+    }
+
+    bp, unlock := bp.acquire()
+    defer unlock()
+
+    return bp.setLevels(Pin(bp.pins_high_low) &^ pins)
+} //SetPinsLow()
+
+// SetPinsIn makes pins inputs and returns the pin states read back.
+func (bp *BP) SetPinsIn(pins Pin) (PinState, error) {
+
+    if err := checkPins(pins, PINS_IO); err != nil {
+        return 0, err
+    }
+
+    bp, unlock := bp.acquire()
+    defer unlock()
+
+    return bp.setDirections(Pin(bp.pins_in_out) | pins)
+} //SetPinsIn()
+
+// SetPinsOut makes pins outputs and returns the pin states read back.
+func (bp *BP) SetPinsOut(pins Pin) (PinState, error) {
+
+    if err := checkPins(pins, PINS_IO); err != nil {
+        return 0, err
+    }
+
+    bp, unlock := bp.acquire()
+    defer unlock()
+
+    return bp.setDirections(Pin(bp.pins_in_out) &^ pins)
+} //SetPinsOut()
+
+// ReadPins samples the pins, by setting their directions to what they
+// already are.
+func (bp *BP) ReadPins() (PinState, error) {
+
+    bp, unlock := bp.acquire()
+    defer unlock()
+
+    return bp.writePins(SET_PINS_IN_OUT | bp.pins_in_out)
+} //ReadPins()
@@ -0,0 +1,280 @@
+package buspirate
+
+import (
+    "errors"
+    "fmt"
+)
+
+const (
+    // http://dangerousprototypes.com/2009/10/27/bus-pirate-binary-raw-wire-mode/
+    // 00000000 – Exit to bitbang mode, responds “BBIOx”
+    // 00000001 – Display mode version string, responds “RAWx”
+    // 0000001x – I2C-style start (0) / stop (1) bit
+    // 0000010x – CS low (0) / high (1)
+    // 00000110 – Read byte
+    // 00000111 – Read bit
+    // 00001000 – Peek at input pin
+    // 00001001 – Clock tick
+    // 0000101x – Clock low (0) / high (1)
+    // 0000110x – Data low (0) / high (1)
+    // 0001xxxx – Bulk transfer, send 1-16 bytes (0=1byte!)
+    // 0010xxxx – Bulk clock ticks, send 1-16 ticks
+    // 0011xxxx – Bulk bits, send 1-8 bits of the next byte (0=1bit!)
+    // 0100wxyz – Configure peripherals w=power, x=pullups, y=AUX, z=CS
+    // 011000xx – Set bus speed, 3=~400kHz, 2=~100kHz, 1=~50kHz, 0=~5kHz
+    // 1000wxyz – Config, w=HiZ/3.3v, x=2/3 wire, y=msb/lsb, z=not used
+
+    RAW_START = 0x02
+    RAW_STOP = 0x03
+    RAW_CS_LOW = 0x04
+    RAW_CS_HIGH = 0x05
+    RAW_READ_BYTE = 0x06
+    RAW_READ_BIT = 0x07
+    RAW_PEEK = 0x08
+    RAW_CLOCK_TICK = 0x09
+    RAW_CLOCK_LOW = 0x0A
+    RAW_CLOCK_HIGH = 0x0B
+    RAW_DATA_LOW = 0x0C
+    RAW_DATA_HIGH = 0x0D
+    RAW_BULK_TRANSFER = 0x10
+    RAW_BULK_CLOCK = 0x20
+    RAW_BULK_BITS = 0x30
+    RAW_BULK_MAX = 16
+    RAW_SET_SPEED = 0x60
+    RAW_SPEED_5K = 0x00
+    RAW_SPEED_50K = 0x01
+    RAW_SPEED_100K = 0x02
+    RAW_SPEED_400K = 0x03
+    RAW_SET_CONFIG = 0x80
+    // Pin output, 0=HiZ, 1=3.3v
+    RAW_CONF_3V3 = 0x08
+    // 0=2-wire, 1=3-wire
+    RAW_CONF_3WIRE = 0x04
+    // Bit order, 0=MSB first, 1=LSB first
+    RAW_CONF_LSB = 0x02
+)
+
+// RawWire is the Bus Pirate's raw 2-wire/3-wire mode, for clocking out
+// protocols the other modes don't know about.
+type RawWire struct {
+    Bp *BP
+}
+
+func NewRawWire(bp *BP) *RawWire {
+    return &RawWire{Bp: bp}
+} //NewRawWire()
+
+// acquire is BP.acquire for a RawWire handle.
+func (raw *RawWire) acquire() (*RawWire, func()) {
+    tx, unlock := raw.Bp.acquire()
+    return &RawWire{Bp: tx}, unlock
+} //acquire()
+
+// Transaction runs f with a handle that holds the BP, so nothing from other
+// goroutines gets in between its commands.
+func (raw *RawWire) Transaction(f func(tx *RawWire) error) error {
+
+    tx, unlock := raw.acquire()
+    defer unlock()
+
+    return f(tx)
+} //Transaction()
+
+// cmd sends a single byte command that answers 0x01.
+func (raw *RawWire) cmd(c uint8) error {
+    err := raw.Bp.modeWriteOK(MODE_RAW, []uint8{c})
+    return err
+} //cmd()
+
+// readCmd sends a single byte command that answers with one data byte.
+func (raw *RawWire) readCmd(c uint8) (uint8, error) {
+
+    bytes, err := raw.Bp.modeWriteRead(MODE_RAW, []uint8{c}, 1)
+    if err != nil {
+        return 0, err
+    }
+
+    return bytes[0], nil
+} //readCmd()
+
+// ConfigurePeripherals sets all of the raw-wire mode peripherals at once.
+func (raw *RawWire) ConfigurePeripherals(p Peripherals) error {
+    return raw.Bp.configurePeripherals(MODE_RAW, p.bits())
+} //ConfigurePeripherals()
+
+// Peripherals returns the raw-wire mode peripherals as last set.
+func (raw *RawWire) Peripherals() Peripherals {
+    return raw.Bp.peripherals(MODE_RAW)
+} //Peripherals()
+
+func (raw *RawWire) setPeriph(bit uint8, on bool) error {
+    return raw.Bp.setPeriph(MODE_RAW, bit, on)
+} //setPeriph()
+
+func (raw *RawWire) Power(on bool) error {
+    return raw.setPeriph(PERIPH_POWER, on)
+} //Power()
+
+func (raw *RawWire) Pullups(on bool) error {
+    return raw.setPeriph(PERIPH_PULLUPS, on)
+} //Pullups()
+
+func (raw *RawWire) AUX(on bool) error {
+    return raw.setPeriph(PERIPH_AUX, on)
+} //AUX()
+
+func (raw *RawWire) CS(on bool) error {
+    return raw.setPeriph(PERIPH_CS, on)
+} //CS()
+
+// Start sends an I2C-style start condition.
+func (raw *RawWire) Start() error {
+    return raw.cmd(RAW_START)
+} //Start()
+
+// Stop sends an I2C-style stop condition.
+func (raw *RawWire) Stop() error {
+    return raw.cmd(RAW_STOP)
+} //Stop()
+
+func (raw *RawWire) CSLow() error {
+    return raw.cmd(RAW_CS_LOW)
+} //CSLow()
+
+func (raw *RawWire) CSHigh() error {
+    return raw.cmd(RAW_CS_HIGH)
+} //CSHigh()
+
+func (raw *RawWire) ReadByte() (byte, error) {
+    return raw.readCmd(RAW_READ_BYTE)
+} //ReadByte()
+
+// ReadBit clocks in a single bit, 0 or 1.
+func (raw *RawWire) ReadBit() (uint8, error) {
+    return raw.readCmd(RAW_READ_BIT)
+} //ReadBit()
+
+// Peek reads the input pin without clocking.
+func (raw *RawWire) Peek() (uint8, error) {
+    return raw.readCmd(RAW_PEEK)
+} //Peek()
+
+func (raw *RawWire) ClockTick() error {
+    return raw.cmd(RAW_CLOCK_TICK)
+} //ClockTick()
+
+func (raw *RawWire) ClockLow() error {
+    return raw.cmd(RAW_CLOCK_LOW)
+} //ClockLow()
+
+func (raw *RawWire) ClockHigh() error {
+    return raw.cmd(RAW_CLOCK_HIGH)
+} //ClockHigh()
+
+func (raw *RawWire) DataLow() error {
+    return raw.cmd(RAW_DATA_LOW)
+} //DataLow()
+
+func (raw *RawWire) DataHigh() error {
+    return raw.cmd(RAW_DATA_HIGH)
+} //DataHigh()
+
+// Transfer clocks out 1-16 bytes and returns a byte read back for each. In
+// 3-wire mode that is the input pin, in 2-wire mode the data line itself.
+func (raw *RawWire) Transfer(data []uint8) ([]uint8, error) {
+
+    if len(data) < 1 || len(data) > RAW_BULK_MAX {
+        return nil, errors.New(fmt.Sprintf(
+            "Can only transfer 1 to %d bytes at a time", RAW_BULK_MAX))
+    }
+
+    sending := []uint8{RAW_BULK_TRANSFER | uint8(len(data)-1)}
+    sending = append(sending, data...)
+
+    res, err := raw.Bp.modeWriteRead(MODE_RAW, sending, len(sending))
+    if err != nil {
+        return res, err
+    }
+
+    if res[0] != 0x01 {
+        return res, &ErrUnexpectedReply{Want: []uint8{0x01}, Got: res[:1]}
+    }
+
+    return res[1:], nil
+} //Transfer()
+
+// ClockTicks sends 1-16 clock ticks.
+func (raw *RawWire) ClockTicks(n int) error {
+
+    if n < 1 || n > RAW_BULK_MAX {
+        return errors.New(fmt.Sprintf("Can only send 1 to %d clock ticks at a time", RAW_BULK_MAX))
+    }
+
+    return raw.cmd(RAW_BULK_CLOCK | uint8(n-1))
+} //ClockTicks()
+
+// WriteBits clocks out the first n (1-8) bits of b, in the configured bit
+// order.
+func (raw *RawWire) WriteBits(b uint8, n int) error {
+
+    if n < 1 || n > 8 {
+        return errors.New(fmt.Sprintf("Can only send 1 to 8 bits at a time, not %d", n))
+    }
+
+    err := raw.Bp.modeWriteOK(MODE_RAW, []uint8{RAW_BULK_BITS | uint8(n-1), b})
+    return err
+} //WriteBits()
+
+// SetSpeed sets the bus speed, one of the RAW_SPEED_* values.
+func (raw *RawWire) SetSpeed(speed uint8) error {
+
+    raw, unlock := raw.acquire()
+    defer unlock()
+
+    if speed > RAW_SPEED_400K {
+        return errors.New(fmt.Sprintf("Invalid raw-wire speed: %d", speed))
+    }
+
+    err := raw.cmd(RAW_SET_SPEED | speed)
+    if err != nil {
+        return err
+    }
+
+    raw.Bp.speed[MODE_RAW] = speed
+    return nil
+} //SetSpeed()
+
+// Speed returns the last speed set with SetSpeed.
+func (raw *RawWire) Speed() uint8 {
+    raw, unlock := raw.acquire()
+    defer unlock()
+
+    return raw.Bp.speed[MODE_RAW]
+} //Speed()
+
+// Configure sets the config bits, an OR of the RAW_CONF_* values.
+func (raw *RawWire) Configure(config uint8) error {
+
+    raw, unlock := raw.acquire()
+    defer unlock()
+
+    if config > 0x0F {
+        return errors.New(fmt.Sprintf("Invalid raw-wire config: %x", config))
+    }
+
+    err := raw.cmd(RAW_SET_CONFIG | config)
+    if err != nil {
+        return err
+    }
+
+    raw.Bp.config[MODE_RAW] = config
+    return nil
+} //Configure()
+
+// Config returns the last config set with Configure.
+func (raw *RawWire) Config() uint8 {
+    raw, unlock := raw.acquire()
+    defer unlock()
+
+    return raw.Bp.config[MODE_RAW]
+} //Config()
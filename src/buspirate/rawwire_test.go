@@ -0,0 +1,97 @@
+package buspirate
+
+import (
+    "testing"
+)
+
+func TestRawWire3Wire (t *testing.T) {
+
+    nbp, em := newEmulatedBP(t)
+    raw, err := nbp.ModeRawWire()
+    if err != nil {
+        t.Fatalf("ModeRawWire failed: %s", err)
+    }
+    if em.Mode() != EMU_RAW {
+        t.Fatalf("Expected raw-wire mode, emulator in mode %d", em.Mode())
+    }
+
+    if err := raw.Configure(RAW_CONF_3V3 | RAW_CONF_3WIRE); err != nil {
+        t.Fatalf("Configure failed: %s", err)
+    }
+
+    em.RawWireSend([]uint8{0x3C, 0xA5, 0x80})
+    res, err := raw.Transfer([]uint8{0x81, 0x00})
+    if err != nil || string(res) != "\x3C\xA5" {
+        t.Fatalf("Expected \\x3C\\xA5, got %q, %s", res, err)
+    }
+
+    bit, err := raw.Peek()
+    if err != nil || bit != 1 {
+        t.Fatalf("Expected to peek a 1, got %d, %s", bit, err)
+    }
+    bit, err = raw.ReadBit()
+    if err != nil || bit != 1 {
+        t.Fatalf("Expected to read a 1, got %d, %s", bit, err)
+    }
+
+    out := em.RawWireReceived()
+    if len(out) != 17 || out[0] != 1 || out[1] != 0 || out[7] != 1 {
+        t.Fatalf("Unexpected bits clocked out: %v", out)
+    }
+} //TestRawWire3Wire()
+
+func TestRawWireBits (t *testing.T) {
+
+    nbp, em := newEmulatedBP(t)
+    raw, err := nbp.ModeRawWire()
+    if err != nil {
+        t.Fatalf("ModeRawWire failed: %s", err)
+    }
+
+    if err := raw.Configure(RAW_CONF_LSB); err != nil {
+        t.Fatalf("Configure failed: %s", err)
+    }
+    if err := raw.WriteBits(0x05, 3); err != nil {
+        t.Fatalf("WriteBits failed: %s", err)
+    }
+    if err := raw.WriteBits(0x00, 9); err == nil {
+        t.Fatalf("Expected an error writing 9 bits")
+    }
+
+    raw.DataHigh()
+    if err := raw.ClockTicks(2); err != nil {
+        t.Fatalf("ClockTicks failed: %s", err)
+    }
+
+    out := em.RawWireReceived()
+    if string(out) != "\x01\x00\x01\x01\x01" {
+        t.Fatalf("Unexpected bits clocked out: %v", out)
+    }
+
+    if err := raw.SetSpeed(RAW_SPEED_400K); err != nil || raw.Speed() != RAW_SPEED_400K {
+        t.Fatalf("SetSpeed failed: %s", err)
+    }
+} //TestRawWireBits()
+
+// Leaving raw-wire is a 0x00 back to bitbang, like every other mode, not a
+// hardware reset that would stop the PWM.
+func TestRawWireExit (t *testing.T) {
+
+    nbp, em := newEmulatedBP(t)
+
+    if err := nbp.BinaryMode(); err != nil {
+        t.Fatalf("BinaryMode failed: %s", err)
+    }
+    if _, _, err := nbp.SetPWM(1000, 50); err != nil {
+        t.Fatalf("SetPWM failed: %s", err)
+    }
+    if _, err := nbp.ModeRawWire(); err != nil {
+        t.Fatalf("ModeRawWire failed: %s", err)
+    }
+    if _, err := nbp.ModeSPI(); err != nil {
+        t.Fatalf("ModeSPI failed: %s", err)
+    }
+    if em.Mode() != EMU_SPI || em.PWM() == nil {
+        t.Fatalf("Expected SPI mode with the PWM still on, mode %d, PWM %v", em.Mode(), em.PWM())
+    }
+} //TestRawWireExit()
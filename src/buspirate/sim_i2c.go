@@ -0,0 +1,452 @@
+package buspirate
+
+import (
+    "sync"
+)
+
+const (
+    // PCA9685 registers used by the simulation
+    SIM_PCA9685_MODE1 = 0x00
+    SIM_PCA9685_LED0 = 0x06
+    SIM_PCA9685_ALL_LED = 0xFA
+    SIM_PCA9685_PRE_SCALE = 0xFE
+    SIM_PCA9685_MODE1_SLEEP = 0x10
+    SIM_PCA9685_MODE1_AI = 0x20
+    SIM_PCA9685_MODE1_RESTART = 0x80
+)
+
+// SimI2CDevice is a device sitting on a SimI2CBus. The bus calls OnStart
+// once the device has been addressed after a START or repeated START, then
+// OnWrite or OnRead for each data byte, and OnStop when the master sends a
+// STOP.
+type SimI2CDevice interface {
+    // Address returns the device's 7-bit address.
+    Address() uint8
+    OnStart(read bool)
+    // OnWrite is handed each byte written to the device and returns true to
+    // ACK it.
+    OnWrite(b uint8) bool
+    // OnRead returns the next byte the device drives onto the bus.
+    OnRead() uint8
+    OnStop()
+}
+
+// SimI2CDevice10 is a SimI2CDevice with a 10-bit address. The bus only
+// matches it against 10-bit addressing, never 7-bit.
+type SimI2CDevice10 interface {
+    SimI2CDevice
+    Address10() uint16
+}
+
+// SimTenBit puts any SimI2CDevice at a 10-bit address.
+type SimTenBit struct {
+    SimI2CDevice
+    Addr uint16
+}
+
+func NewSimTenBit(addr uint16, dev SimI2CDevice) *SimTenBit {
+    return &SimTenBit{SimI2CDevice: dev, Addr: addr}
+} //NewSimTenBit()
+
+func (tb *SimTenBit) Address10() uint16 {
+    return tb.Addr
+} //Address10()
+
+// SimI2CBus routes the START, STOP, read and write events of an emulated I2C
+// master to the attached devices.
+type SimI2CBus struct {
+    mu sync.Mutex
+    devices []SimI2CDevice
+    active SimI2CDevice
+    addressing bool
+    read bool
+    // Top two bits of a 10-bit address waiting for its second byte.
+    ten_hi int
+    // The 10-bit device last addressed for writing, which a repeated START
+    // with the 10-bit read header addresses again.
+    ten_last SimI2CDevice10
+}
+
+func NewSimI2CBus() *SimI2CBus {
+    return &SimI2CBus{ten_hi: -1}
+} //NewSimI2CBus()
+
+// Attach puts dev on the bus.
+func (bus *SimI2CBus) Attach(dev SimI2CDevice) {
+    bus.mu.Lock()
+    bus.devices = append(bus.devices, dev)
+    bus.mu.Unlock()
+} //Attach()
+
+// Start signals a START or repeated START, the next byte written is an
+// address.
+func (bus *SimI2CBus) Start() {
+    bus.mu.Lock()
+    bus.addressing = true
+    bus.ten_hi = -1
+    bus.mu.Unlock()
+} //Start()
+
+func (bus *SimI2CBus) Stop() {
+    bus.mu.Lock()
+    defer bus.mu.Unlock()
+
+    if bus.active != nil {
+        bus.active.OnStop()
+    }
+    bus.active = nil
+    bus.addressing = false
+    bus.ten_hi = -1
+    bus.ten_last = nil
+} //Stop()
+
+// Write clocks b out onto the bus and returns true if it was ACKed.
+func (bus *SimI2CBus) Write(b uint8) bool {
+    bus.mu.Lock()
+    defer bus.mu.Unlock()
+
+    if bus.addressing {
+        bus.addressing = false
+        bus.active = nil
+        bus.read = b & I2C_READ_BIT == I2C_READ_BIT
+
+        if b &^ 0x07 == I2C_ADDR_10BIT {
+            return bus.address10(b)
+        }
+
+        for _, dev := range bus.devices {
+            if _, ten := dev.(SimI2CDevice10); ten {
+                continue
+            }
+            if dev.Address() == b >> 1 {
+                bus.active = dev
+                dev.OnStart(bus.read)
+                return true
+            }
+        }
+
+        return false
+    }
+
+    if bus.ten_hi >= 0 {
+        return bus.address10Low(b)
+    }
+
+    if bus.active == nil || bus.read {
+        return false
+    }
+
+    return bus.active.OnWrite(b)
+} //Write()
+
+// address10 handles the first byte of a 10-bit address. For a write the
+// second byte picks the device, a read goes back to the device last written
+// to with the same top bits.
+func (bus *SimI2CBus) address10(b uint8) bool {
+
+    hi := uint16(b >> 1 & 0x03)
+    if bus.read {
+        dev := bus.ten_last
+        if dev == nil || dev.Address10() >> 8 != hi {
+            return false
+        }
+        bus.active = dev
+        dev.OnStart(true)
+        return true
+    }
+
+    for _, dev := range bus.devices {
+        if dev10, ok := dev.(SimI2CDevice10); ok && dev10.Address10() >> 8 == hi {
+            bus.ten_hi = int(hi)
+            return true
+        }
+    }
+
+    return false
+} //address10()
+
+// address10Low handles the second byte of a 10-bit address.
+func (bus *SimI2CBus) address10Low(b uint8) bool {
+
+    addr := uint16(bus.ten_hi) << 8 | uint16(b)
+    bus.ten_hi = -1
+
+    for _, dev := range bus.devices {
+        if dev10, ok := dev.(SimI2CDevice10); ok && dev10.Address10() == addr {
+            bus.active = dev10
+            bus.ten_last = dev10
+            dev10.OnStart(false)
+            return true
+        }
+    }
+
+    return false
+} //address10Low()
+
+// Read clocks a byte in from the addressed device. With no device driving
+// the bus the pullups read 0xFF.
+func (bus *SimI2CBus) Read() uint8 {
+    bus.mu.Lock()
+    defer bus.mu.Unlock()
+
+    if bus.active == nil || !bus.read {
+        return 0xFF
+    }
+
+    return bus.active.OnRead()
+} //Read()
+
+// SimRegisters is a simple register-mapped device: the first bytes written
+// after the address set the register pointer, further writes store into
+// consecutive registers, and reads return consecutive registers. The pointer
+// wraps at the end of Regs.
+type SimRegisters struct {
+    Addr uint8
+    // PtrBytes is the width of the register pointer, 1 or 2 bytes, MSB first.
+    PtrBytes int
+    Regs []uint8
+
+    ptr int
+    ptr_left int
+}
+
+func NewSimRegisters(addr uint8, ptr_bytes int, size int) *SimRegisters {
+    return &SimRegisters{Addr: addr, PtrBytes: ptr_bytes, Regs: make([]uint8, size)}
+} //NewSimRegisters()
+
+// NewSim24C02 returns a simulated 256 byte 24C02 EEPROM.
+func NewSim24C02(addr uint8) *SimRegisters {
+    mem := NewSimRegisters(addr, 1, 256)
+    for i := range mem.Regs {
+        mem.Regs[i] = 0xFF
+    }
+    return mem
+} //NewSim24C02()
+
+// NewSim24C32 returns a simulated 4K byte 24C32 EEPROM, with a 16-bit
+// address pointer.
+func NewSim24C32(addr uint8) *SimRegisters {
+    mem := NewSimRegisters(addr, 2, 4096)
+    for i := range mem.Regs {
+        mem.Regs[i] = 0xFF
+    }
+    return mem
+} //NewSim24C32()
+
+func (sr *SimRegisters) Address() uint8 {
+    return sr.Addr
+} //Address()
+
+func (sr *SimRegisters) OnStart(read bool) {
+    if !read {
+        sr.ptr_left = sr.PtrBytes
+    }
+} //OnStart()
+
+func (sr *SimRegisters) OnWrite(b uint8) bool {
+
+    if sr.ptr_left > 0 {
+        if sr.ptr_left == sr.PtrBytes {
+            sr.ptr = 0
+        }
+        sr.ptr = (sr.ptr << 8) | int(b)
+        sr.ptr_left--
+        if sr.ptr_left == 0 {
+            sr.ptr %= len(sr.Regs)
+        }
+        return true
+    }
+
+    sr.Regs[sr.ptr] = b
+    sr.ptr = (sr.ptr + 1) % len(sr.Regs)
+    return true
+} //OnWrite()
+
+func (sr *SimRegisters) OnRead() uint8 {
+    b := sr.Regs[sr.ptr]
+    sr.ptr = (sr.ptr + 1) % len(sr.Regs)
+    return b
+} //OnRead()
+
+func (sr *SimRegisters) OnStop() {
+    sr.ptr_left = 0
+} //OnStop()
+
+// SimPCA9685 simulates the register behaviour of an NXP PCA9685 16 channel
+// PWM controller: power-on register values, MODE1 auto-increment, the
+// ALL_LED registers, and PRE_SCALE only being writable while asleep.
+type SimPCA9685 struct {
+    Addr uint8
+    Regs [256]uint8
+
+    ptr uint8
+    ptr_set bool
+}
+
+func NewSimPCA9685(addr uint8) *SimPCA9685 {
+    pca := &SimPCA9685{Addr: addr}
+    pca.Regs[SIM_PCA9685_MODE1] = 0x11
+    pca.Regs[SIM_PCA9685_MODE1 + 1] = 0x04
+    pca.Regs[SIM_PCA9685_PRE_SCALE] = 0x1E
+    return pca
+} //NewSimPCA9685()
+
+// Channel returns the 12-bit on and off counts of channel ch, with bit 12
+// set for full on or full off.
+func (pca *SimPCA9685) Channel(ch int) (uint16, uint16) {
+    r := SIM_PCA9685_LED0 + 4 * ch
+    on := uint16(pca.Regs[r]) | uint16(pca.Regs[r + 1]) << 8
+    off := uint16(pca.Regs[r + 2]) | uint16(pca.Regs[r + 3]) << 8
+    return on, off
+} //Channel()
+
+func (pca *SimPCA9685) Address() uint8 {
+    return pca.Addr
+} //Address()
+
+func (pca *SimPCA9685) OnStart(read bool) {
+    if !read {
+        pca.ptr_set = false
+    }
+} //OnStart()
+
+func (pca *SimPCA9685) next() {
+    if pca.Regs[SIM_PCA9685_MODE1] & SIM_PCA9685_MODE1_AI != 0 {
+        pca.ptr++
+    }
+} //next()
+
+func (pca *SimPCA9685) OnWrite(b uint8) bool {
+
+    if !pca.ptr_set {
+        pca.ptr = b
+        pca.ptr_set = true
+        return true
+    }
+
+    switch {
+        case pca.ptr == SIM_PCA9685_MODE1:
+            // Writing a 1 to RESTART clears it.
+            pca.Regs[pca.ptr] = b &^ SIM_PCA9685_MODE1_RESTART
+        case pca.ptr == SIM_PCA9685_PRE_SCALE:
+            if pca.Regs[SIM_PCA9685_MODE1] & SIM_PCA9685_MODE1_SLEEP != 0 {
+                pca.Regs[pca.ptr] = b
+            }
+        case pca.ptr >= SIM_PCA9685_ALL_LED && pca.ptr < SIM_PCA9685_PRE_SCALE:
+            pca.Regs[pca.ptr] = b
+            off := pca.ptr - SIM_PCA9685_ALL_LED
+            for ch := 0; ch < 16; ch++ {
+                pca.Regs[SIM_PCA9685_LED0 + 4 * ch + int(off)] = b
+            }
+        default:
+            pca.Regs[pca.ptr] = b
+    }
+
+    pca.next()
+    return true
+} //OnWrite()
+
+func (pca *SimPCA9685) OnRead() uint8 {
+    b := pca.Regs[pca.ptr]
+    pca.next()
+    return b
+} //OnRead()
+
+func (pca *SimPCA9685) OnStop() {
+    pca.ptr_set = false
+} //OnStop()
+
+// SimSMBus is an SMBus device: the first byte written is the command, the
+// rest its data. Reads return Regs[cmd], or with Process set the result of
+// Process(cmd, data written) for process calls. With PEC set it appends a
+// PEC to every read and checks the PEC of writes, dropping bad ones.
+type SimSMBus struct {
+    Addr uint8
+    PEC bool
+    Regs map[uint8][]uint8
+    Process func(cmd uint8, w []uint8) []uint8
+    // Written has the data of the last good write for each command.
+    Written map[uint8][]uint8
+    // BadPEC counts writes dropped for a bad PEC.
+    BadPEC int
+
+    cmd uint8
+    w []uint8
+    wire []uint8
+    reply []uint8
+    read_ptr int
+}
+
+func NewSimSMBus(addr uint8) *SimSMBus {
+    return &SimSMBus{
+        Addr: addr,
+        Regs: make(map[uint8][]uint8),
+        Written: make(map[uint8][]uint8),
+    }
+} //NewSimSMBus()
+
+func (sm *SimSMBus) Address() uint8 {
+    return sm.Addr
+} //Address()
+
+func (sm *SimSMBus) OnStart(read bool) {
+
+    if !read {
+        sm.w = nil
+        sm.wire = []uint8{sm.Addr << 1 | I2C_WRITE_BIT}
+        return
+    }
+
+    sm.wire = append(sm.wire, sm.Addr << 1 | I2C_READ_BIT)
+    if len(sm.w) > 0 {
+        sm.cmd = sm.w[0]
+    }
+
+    if sm.Process != nil && len(sm.w) > 1 {
+        sm.reply = sm.Process(sm.cmd, sm.w[1:])
+    } else {
+        sm.reply = append([]uint8{}, sm.Regs[sm.cmd]...)
+    }
+    if sm.PEC {
+        sm.reply = append(sm.reply, SMBusPEC(append(sm.wire, sm.reply...)))
+    }
+    sm.read_ptr = 0
+    // The write half is used up by the read.
+    sm.w = nil
+} //OnStart()
+
+func (sm *SimSMBus) OnWrite(b uint8) bool {
+    sm.w = append(sm.w, b)
+    sm.wire = append(sm.wire, b)
+    return true
+} //OnWrite()
+
+func (sm *SimSMBus) OnRead() uint8 {
+
+    if sm.read_ptr >= len(sm.reply) {
+        return 0xFF
+    }
+
+    b := sm.reply[sm.read_ptr]
+    sm.read_ptr++
+    return b
+} //OnRead()
+
+func (sm *SimSMBus) OnStop() {
+
+    w := sm.w
+    sm.w = nil
+    if len(w) == 0 {
+        return
+    }
+
+    if sm.PEC {
+        if len(w) < 2 || SMBusPEC(sm.wire[:len(sm.wire)-1]) != w[len(w)-1] {
+            sm.BadPEC++
+            return
+        }
+        w = w[:len(w)-1]
+    }
+
+    sm.Written[w[0]] = w[1:]
+} //OnStop()
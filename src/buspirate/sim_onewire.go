@@ -0,0 +1,123 @@
+package buspirate
+
+import (
+    "math"
+    "math/bits"
+    "sort"
+)
+
+// SimOneWireDevice is a device on the emulator's 1-Wire bus. The emulator
+// handles the ROM commands itself; once a device has been selected it is
+// handed the following bytes with OnWrite and asked for bytes with OnRead.
+// OnReset is called on every bus reset.
+type SimOneWireDevice interface {
+    ROM() ROMCode
+    // Alarm reports whether the device answers an ALARM SEARCH.
+    Alarm() bool
+    OnReset()
+    OnWrite(b uint8)
+    OnRead() uint8
+}
+
+// simOneWireSearch returns ROM codes in the order the 1-Wire search
+// algorithm finds them: taking the 0 branch first on every bit, LSB first.
+func simOneWireSearch(devs []SimOneWireDevice, alarm bool) []ROMCode {
+
+    var res []ROMCode
+    for _, dev := range devs {
+        if !alarm || dev.Alarm() {
+            res = append(res, dev.ROM())
+        }
+    }
+
+    sort.Slice(res, func(i, j int) bool {
+        return bits.Reverse64(uint64(res[i])) < bits.Reverse64(uint64(res[j]))
+    })
+
+    return res
+} //simOneWireSearch()
+
+// SimDS18B20 simulates a DS18B20 temperature sensor.
+type SimDS18B20 struct {
+    Rom ROMCode
+    // Temp is the temperature, in Celsius, the next conversion measures.
+    Temp float64
+
+    scratch [DS18B20_SCRATCHPAD_SIZE]uint8
+    cmd uint8
+    args []uint8
+    rd int
+}
+
+// NewSimDS18B20 returns a simulated sensor with the given 48-bit serial
+// number, reading temp, with the power on scratchpad.
+func NewSimDS18B20(serial uint64, temp float64) *SimDS18B20 {
+    ds := &SimDS18B20{Rom: NewROMCode(DS18B20_FAMILY, serial), Temp: temp}
+    // 85C power on reading, TH, TL, 12-bit config and reserved bytes.
+    copy(ds.scratch[:], []uint8{0x50, 0x05, 0x4B, 0x46, 0x7F, 0xFF, 0x0C, 0x10})
+    ds.scratch[DS18B20_CRC] = OneWireCRC8(ds.scratch[:DS18B20_CRC])
+    return ds
+} //NewSimDS18B20()
+
+func (ds *SimDS18B20) ROM() ROMCode {
+    return ds.Rom
+} //ROM()
+
+// Alarm reports whether the last conversion is outside TH/TL.
+func (ds *SimDS18B20) Alarm() bool {
+    t := int8(int16(uint16(ds.scratch[DS18B20_TEMP_MSB]) << 8 |
+        uint16(ds.scratch[DS18B20_TEMP_LSB])) >> 4)
+    return t >= int8(ds.scratch[DS18B20_TH]) || t <= int8(ds.scratch[DS18B20_TL])
+} //Alarm()
+
+func (ds *SimDS18B20) OnReset() {
+    ds.cmd = 0
+    ds.args = nil
+    ds.rd = 0
+} //OnReset()
+
+func (ds *SimDS18B20) convert() {
+    // Drop the bits the configured resolution doesn't have.
+    res := (ds.scratch[DS18B20_CONFIG] >> 5) & 0x03
+    raw := int16(math.Round(ds.Temp * 16))
+    raw &^= int16(1 << (3 - res)) - 1
+    ds.scratch[DS18B20_TEMP_LSB] = uint8(raw)
+    ds.scratch[DS18B20_TEMP_MSB] = uint8(uint16(raw) >> 8)
+    ds.scratch[DS18B20_CRC] = OneWireCRC8(ds.scratch[:DS18B20_CRC])
+} //convert()
+
+func (ds *SimDS18B20) OnWrite(b uint8) {
+
+    if ds.cmd == 0 {
+        ds.cmd = b
+        switch b {
+            case DS18B20_CONVERT_T:
+                ds.convert()
+            case DS18B20_READ_SCRATCHPAD:
+                ds.rd = 0
+        }
+        return
+    }
+
+    if ds.cmd == DS18B20_WRITE_SCRATCHPAD {
+        ds.args = append(ds.args, b)
+        if len(ds.args) == 3 {
+            ds.scratch[DS18B20_TH] = ds.args[0]
+            ds.scratch[DS18B20_TL] = ds.args[1]
+            ds.scratch[DS18B20_CONFIG] = ds.args[2] & 0x60 | 0x1F
+            ds.scratch[DS18B20_CRC] = OneWireCRC8(ds.scratch[:DS18B20_CRC])
+        }
+    }
+} //OnWrite()
+
+func (ds *SimDS18B20) OnRead() uint8 {
+
+    if ds.cmd == DS18B20_READ_SCRATCHPAD && ds.rd < len(ds.scratch) {
+        b := ds.scratch[ds.rd]
+        ds.rd++
+        return b
+    }
+
+    // Conversions finish instantly, so the read slot polls as done.
+    return 0xFF
+} //OnRead()
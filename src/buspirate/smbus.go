@@ -0,0 +1,310 @@
+package buspirate
+
+import (
+    "errors"
+    "fmt"
+    "math"
+)
+
+const (
+    // http://smbus.org/specs/SMBus_3_1_20180319.pdf
+    // Largest block read or write
+    SMBUS_BLOCK_MAX = 32
+)
+
+// SMBus is the SMBus protocol on top of an I2C mode Bus Pirate. Addresses are
+// 7-bit, words are little endian as on the wire. With PEC set every
+// transaction carries a Packet Error Code, which is checked on reads.
+type SMBus struct {
+    I2C *I2C
+    PEC bool
+}
+
+func NewSMBus(i2c *I2C) *SMBus {
+    return &SMBus{I2C: i2c}
+} //NewSMBus()
+
+// SMBusPEC is the SMBus Packet Error Code, CRC-8 (x^8 + x^2 + x + 1), over
+// every byte of a transaction including the address bytes.
+func SMBusPEC(data []uint8) uint8 {
+
+    var crc uint8
+    for _, b := range data {
+        crc ^= b
+        for i := 0; i < 8; i++ {
+            if crc & 0x80 != 0 {
+                crc = crc << 1 ^ 0x07
+            } else {
+                crc <<= 1
+            }
+        }
+    }
+
+    return crc
+} //SMBusPEC()
+
+// write runs a write only transaction: START, the address, w, the PEC if on,
+// then STOP.
+func (sm *SMBus) write(addr uint8, w []uint8) error {
+
+    if err := checkAddr(addr); err != nil {
+        return err
+    }
+
+    i2c, unlock := sm.I2C.acquire()
+    defer unlock()
+
+    sending := append([]uint8{}, w...)
+    if sm.PEC {
+        sending = append(sending, SMBusPEC(append([]uint8{addr << 1}, w...)))
+    }
+
+    _, err := i2c.Start()
+    if err != nil {
+        return err
+    }
+
+    err = i2c.writeTo(addr << 1 | I2C_WRITE_BIT, sending)
+
+    _, stop_err := i2c.Stop(addr)
+    if err == nil {
+        err = stop_err
+    }
+
+    return err
+} //write()
+
+// writeRead writes w, if there is any, then after a repeated START reads n
+// bytes, or with block set a count byte and that many bytes after it. The
+// PEC, if on, is read and checked at the end.
+func (sm *SMBus) writeRead(addr uint8, w []uint8, n int, block bool) ([]uint8, error) {
+
+    if err := checkAddr(addr); err != nil {
+        return nil, err
+    }
+
+    i2c, unlock := sm.I2C.acquire()
+    defer unlock()
+
+    // Everything on the wire, for the PEC.
+    var sent []uint8
+
+    _, err := i2c.Start()
+    if err != nil {
+        return nil, err
+    }
+
+    var res []uint8
+    err = func() error {
+        if len(w) > 0 {
+            sent = append(sent, addr << 1 | I2C_WRITE_BIT)
+            sent = append(sent, w...)
+            if err := i2c.writeTo(addr << 1 | I2C_WRITE_BIT, w); err != nil {
+                return err
+            }
+            if _, err := i2c.Start(); err != nil {
+                return err
+            }
+        }
+
+        sent = append(sent, addr << 1 | I2C_READ_BIT)
+        acks, err := i2c.SendBytesTo(addr << 1 | I2C_READ_BIT, nil)
+        if err != nil {
+            return err
+        }
+        if acks[0] != 0x00 {
+            return &ErrNACK{Addr: uint16(addr)}
+        }
+
+        pec := 0
+        if sm.PEC {
+            pec = 1
+        }
+
+        if block {
+            count, err := i2c.ReadByte()
+            if err != nil {
+                return err
+            }
+
+            // NACK the count if nothing follows it: an empty block without
+            // a PEC, or one too long to read.
+            n = int(count)
+            if n > SMBUS_BLOCK_MAX || n + pec == 0 {
+                err = i2c.NACK()
+            } else {
+                err = i2c.ACK()
+            }
+            if err != nil {
+                return err
+            }
+            if n > SMBUS_BLOCK_MAX {
+                return errors.New(fmt.Sprintf("SMBus block too long: %d", count))
+            }
+            res = []uint8{count}
+        }
+
+        if n + pec == 0 {
+            return nil
+        }
+
+        data, err := i2c.readBytes(n + pec, true)
+        res = append(res, data...)
+        return err
+    }()
+
+    _, stop_err := i2c.Stop(addr)
+    if err == nil {
+        err = stop_err
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    if sm.PEC {
+        pec := res[len(res)-1]
+        res = res[:len(res)-1]
+        if SMBusPEC(append(sent, res...)) != pec {
+            err = ErrPEC
+        }
+    }
+
+    if block {
+        // Drop the count byte.
+        res = res[1:]
+    }
+
+    return res, err
+} //writeRead()
+
+// QuickCommand sends just the address, the R/W bit is the data.
+func (sm *SMBus) QuickCommand(addr uint8, read bool) error {
+
+    if err := checkAddr(addr); err != nil {
+        return err
+    }
+
+    i2c, unlock := sm.I2C.acquire()
+    defer unlock()
+
+    addr_rw := addr << 1 | I2C_WRITE_BIT
+    if read {
+        addr_rw = addr << 1 | I2C_READ_BIT
+    }
+
+    _, err := i2c.Start()
+    if err != nil {
+        return err
+    }
+
+    acks, err := i2c.SendBytesTo(addr_rw, nil)
+    if err == nil && acks[0] != 0x00 {
+        err = &ErrNACK{Addr: uint16(addr)}
+    }
+
+    _, stop_err := i2c.Stop(addr)
+    if err == nil {
+        err = stop_err
+    }
+
+    return err
+} //QuickCommand()
+
+func (sm *SMBus) SendByte(addr uint8, b uint8) error {
+    return sm.write(addr, []uint8{b})
+} //SendByte()
+
+func (sm *SMBus) ReceiveByte(addr uint8) (uint8, error) {
+
+    res, err := sm.writeRead(addr, nil, 1, false)
+    if err != nil {
+        return 0, err
+    }
+
+    return res[0], nil
+} //ReceiveByte()
+
+func (sm *SMBus) WriteByteData(addr uint8, cmd uint8, b uint8) error {
+    return sm.write(addr, []uint8{cmd, b})
+} //WriteByteData()
+
+func (sm *SMBus) ReadByteData(addr uint8, cmd uint8) (uint8, error) {
+
+    res, err := sm.writeRead(addr, []uint8{cmd}, 1, false)
+    if err != nil {
+        return 0, err
+    }
+
+    return res[0], nil
+} //ReadByteData()
+
+func (sm *SMBus) WriteWordData(addr uint8, cmd uint8, w uint16) error {
+    return sm.write(addr, []uint8{cmd, uint8(w), uint8(w >> 8)})
+} //WriteWordData()
+
+func (sm *SMBus) ReadWordData(addr uint8, cmd uint8) (uint16, error) {
+
+    res, err := sm.writeRead(addr, []uint8{cmd}, 2, false)
+    if err != nil {
+        return 0, err
+    }
+
+    return uint16(res[1]) << 8 | uint16(res[0]), nil
+} //ReadWordData()
+
+// BlockWrite writes a count byte then up to 32 bytes of data.
+func (sm *SMBus) BlockWrite(addr uint8, cmd uint8, data []uint8) error {
+
+    if len(data) > SMBUS_BLOCK_MAX {
+        return errors.New(fmt.Sprintf("SMBus blocks are at most %d bytes", SMBUS_BLOCK_MAX))
+    }
+
+    w := append([]uint8{cmd, uint8(len(data))}, data...)
+    return sm.write(addr, w)
+} //BlockWrite()
+
+// BlockRead reads a block, however long the device says it is.
+func (sm *SMBus) BlockRead(addr uint8, cmd uint8) ([]uint8, error) {
+    return sm.writeRead(addr, []uint8{cmd}, 0, true)
+} //BlockRead()
+
+// ProcessCall writes a word and reads the device's word reply.
+func (sm *SMBus) ProcessCall(addr uint8, cmd uint8, w uint16) (uint16, error) {
+
+    res, err := sm.writeRead(addr, []uint8{cmd, uint8(w), uint8(w >> 8)}, 2, false)
+    if err != nil {
+        return 0, err
+    }
+
+    return uint16(res[1]) << 8 | uint16(res[0]), nil
+} //ProcessCall()
+
+// BlockProcessCall writes a block and reads the device's block reply.
+func (sm *SMBus) BlockProcessCall(addr uint8, cmd uint8, data []uint8) ([]uint8, error) {
+
+    if len(data) > SMBUS_BLOCK_MAX {
+        return nil, errors.New(fmt.Sprintf("SMBus blocks are at most %d bytes", SMBUS_BLOCK_MAX))
+    }
+
+    w := append([]uint8{cmd, uint8(len(data))}, data...)
+    return sm.writeRead(addr, w, 0, true)
+} //BlockProcessCall()
+
+// PMBusLinear11 decodes the PMBus LINEAR11 format: a 5-bit two's complement
+// exponent over an 11-bit two's complement mantissa.
+func PMBusLinear11(v uint16) float64 {
+
+    exp := int(int16(v) >> 11)
+    mant := int(int16(v << 5) >> 5)
+
+    return math.Ldexp(float64(mant), exp)
+} //PMBusLinear11()
+
+// PMBusLinear16 decodes the PMBus LINEAR16 format used for output voltages:
+// an unsigned mantissa, with the exponent in the low 5 bits of VOUT_MODE.
+func PMBusLinear16(v uint16, vout_mode uint8) float64 {
+
+    exp := int(int8(vout_mode << 3) >> 3)
+
+    return math.Ldexp(float64(v), exp)
+} //PMBusLinear16()
@@ -0,0 +1,161 @@
+package buspirate
+
+import (
+    "errors"
+    "strings"
+    "testing"
+)
+
+func TestSMBusPEC (t *testing.T) {
+
+    // CRC-8 check value for "123456789"
+    if pec := SMBusPEC([]uint8("123456789")); pec != 0xF4 {
+        t.Fatalf("Expected PEC F4, got %X", pec)
+    }
+} //TestSMBusPEC()
+
+func TestSMBus (t *testing.T) {
+
+    dev := NewSimSMBus(0x0B)
+    dev.Regs[0x08] = []uint8{0x34, 0x12}
+    dev.Regs[0x0D] = []uint8{0x42}
+    dev.Regs[0x20] = []uint8{0x04, 'A', 'B', 'C', 'D'}
+    dev.Process = func(cmd uint8, w []uint8) []uint8 {
+        // Reverse the data, after the count byte for a block.
+        if cmd == 0x30 {
+            w = w[1:]
+        }
+        out := make([]uint8, len(w))
+        for i := range w {
+            out[len(w)-1-i] = w[i]
+        }
+        if cmd == 0x30 {
+            return append([]uint8{uint8(len(out))}, out...)
+        }
+        return out
+    }
+
+    i2c, _ := newEmulatedI2C(t, dev)
+
+    for _, pec := range []bool{false, true} {
+        sm := NewSMBus(i2c)
+        sm.PEC = pec
+        dev.PEC = pec
+
+        if err := sm.QuickCommand(0x0B, false); err != nil {
+            t.Fatalf("QuickCommand failed: %s", err)
+        }
+        var nack *ErrNACK
+        if err := sm.QuickCommand(0x0C, true); !errors.As(err, &nack) {
+            t.Fatalf("Expected ErrNACK, got: %v", err)
+        }
+
+        if err := sm.WriteWordData(0x0B, 0x01, 0xBEEF); err != nil {
+            t.Fatalf("WriteWordData failed: %s", err)
+        }
+        if w := dev.Written[0x01]; len(w) != 2 || w[0] != 0xEF || w[1] != 0xBE {
+            t.Fatalf("Expected EF BE written, got %X", w)
+        }
+        if err := sm.BlockWrite(0x0B, 0x02, []uint8("xyz")); err != nil {
+            t.Fatalf("BlockWrite failed: %s", err)
+        }
+        if w := dev.Written[0x02]; string(w) != "\x03xyz" {
+            t.Fatalf("Expected block xyz written, got %q", w)
+        }
+
+        if w, err := sm.ReadWordData(0x0B, 0x08); err != nil || w != 0x1234 {
+            t.Fatalf("ReadWordData expected 1234, got %X, %v", w, err)
+        }
+        if b, err := sm.ReadByteData(0x0B, 0x0D); err != nil || b != 0x42 {
+            t.Fatalf("ReadByteData expected 42, got %X, %v", b, err)
+        }
+        if err := sm.SendByte(0x0B, 0x0D); err != nil {
+            t.Fatalf("SendByte failed: %s", err)
+        }
+        if b, err := sm.ReceiveByte(0x0B); err != nil || b != 0x42 {
+            t.Fatalf("ReceiveByte expected 42, got %X, %v", b, err)
+        }
+        if blk, err := sm.BlockRead(0x0B, 0x20); err != nil || string(blk) != "ABCD" {
+            t.Fatalf("BlockRead expected ABCD, got %q, %v", blk, err)
+        }
+
+        if w, err := sm.ProcessCall(0x0B, 0x10, 0x1234); err != nil || w != 0x3412 {
+            t.Fatalf("ProcessCall expected 3412, got %X, %v", w, err)
+        }
+        blk, err := sm.BlockProcessCall(0x0B, 0x30, []uint8("abc"))
+        if err != nil || string(blk) != "cba" {
+            t.Fatalf("BlockProcessCall expected cba, got %q, %v", blk, err)
+        }
+        if dev.BadPEC != 0 {
+            t.Fatalf("Device saw %d bad PECs", dev.BadPEC)
+        }
+    }
+
+    // The device sending no PEC reads as a bad one.
+    dev.PEC = false
+    sm := NewSMBus(i2c)
+    sm.PEC = true
+    if _, err := sm.ReadWordData(0x0B, 0x08); !errors.Is(err, ErrPEC) {
+        t.Fatalf("Expected ErrPEC, got: %v", err)
+    }
+    // The data still comes back, without the count byte.
+    if blk, err := sm.BlockRead(0x0B, 0x20); !errors.Is(err, ErrPEC) || string(blk) != "ABCD" {
+        t.Fatalf("Expected ABCD and ErrPEC, got %q, %v", blk, err)
+    }
+} //TestSMBus()
+
+// An empty block's count byte is the last byte read, so it gets the NACK.
+func TestSMBusEmptyBlock (t *testing.T) {
+
+    dev := NewSimSMBus(0x0B)
+    dev.Regs[0x21] = []uint8{0x00}
+
+    em := NewEmulator()
+    em.AttachI2C(dev)
+    rec := &recordTransport{Transport: em.Transport()}
+    nbp := NewBPWithTransport(rec)
+    if err := nbp.Init(); err != nil {
+        t.Fatalf("Init failed: %s", err)
+    }
+    t.Cleanup(func() { nbp.Close() })
+    i2c, err := nbp.ModeI2C()
+    if err != nil {
+        t.Fatalf("ModeI2C failed: %s", err)
+    }
+
+    sm := NewSMBus(i2c)
+    blk, err := sm.BlockRead(0x0B, 0x21)
+    if err != nil || len(blk) != 0 {
+        t.Fatalf("Expected an empty block, got %q, %v", blk, err)
+    }
+
+    rec.mu.Lock()
+    sent := string(rec.sent)
+    rec.mu.Unlock()
+    want := string([]uint8{I2C_READ_BYTE, I2C_SEND_NACK, I2C_SEND_STOP})
+    if !strings.HasSuffix(sent, want) {
+        t.Fatalf("Expected the count NACKed, sent %X", sent)
+    }
+
+    sm.PEC = true
+    dev.PEC = true
+    if blk, err := sm.BlockRead(0x0B, 0x21); err != nil || len(blk) != 0 {
+        t.Fatalf("Expected an empty block with PEC, got %q, %v", blk, err)
+    }
+} //TestSMBusEmptyBlock()
+
+func TestPMBusLinear (t *testing.T) {
+
+    // -2 exponent, mantissa 100
+    if v := PMBusLinear11(0xF064); v != 25.0 {
+        t.Fatalf("Linear11 expected 25, got %v", v)
+    }
+    // -1 exponent, mantissa -8
+    if v := PMBusLinear11(0xFFF8); v != -4.0 {
+        t.Fatalf("Linear11 expected -4, got %v", v)
+    }
+    // VOUT_MODE exponent -12
+    if v := PMBusLinear16(0x3000, 0x14); v != 3.0 {
+        t.Fatalf("Linear16 expected 3, got %v", v)
+    }
+} //TestPMBusLinear()
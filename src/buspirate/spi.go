@@ -0,0 +1,241 @@
+package buspirate
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "time"
+)
+
+const (
+    // http://dangerousprototypes.com/2009/10/08/bus-pirate-raw-spi-mode/
+    // 00000000 – Enter raw bitbang mode, reset SPI mode
+    // 00000001 – Enter raw SPI mode, display version string (SPI1)
+    // 0000001x – CS high (1) or low (0)
+    // 00000100 – Write then read, CS low during transfer
+    // 00000101 – Write then read, no CS
+    // 0001xxxx – Bulk SPI transfer, send/read 1-16 bytes (0=1byte!)
+    // 0100wxyz – Configure peripherals w=power, x=pull-ups, y=AUX, z=CS
+    // 01100xxx – SPI speed
+    // 1000wxyz – SPI config, w=HiZ/3.3v, x=CKP idle, y=CKE edge, z=SMP sample
+
+    SPI_CS_LOW = 0x02
+    SPI_CS_HIGH = 0x03
+    SPI_WRITE_READ = 0x04
+    SPI_WRITE_READ_NO_CS = 0x05
+    SPI_BULK_TRANSFER = 0x10
+    SPI_BULK_MAX = 16
+    SPI_WRITE_READ_MAX = 4096
+    // Worst case time for one byte on the bus, µs, at 30kHz
+    SPI_BYTE_TIMEOUT = 300
+    SPI_SET_SPEED = 0x60
+    SPI_SPEED_30K = 0x00
+    SPI_SPEED_125K = 0x01
+    SPI_SPEED_250K = 0x02
+    SPI_SPEED_1M = 0x03
+    SPI_SPEED_2M = 0x04
+    SPI_SPEED_2_6M = 0x05
+    SPI_SPEED_4M = 0x06
+    SPI_SPEED_8M = 0x07
+    SPI_SET_CONFIG = 0x80
+    // Pin output, 0=HiZ, 1=3.3v
+    SPI_CONF_OUT_3V3 = 0x08
+    // Clock idle phase, 0=low, 1=high
+    SPI_CONF_CKP_HIGH = 0x04
+    // Clock edge, 0=idle to active, 1=active to idle
+    SPI_CONF_CKE_ACTIVE_IDLE = 0x02
+    // Sample point, 0=middle, 1=end
+    SPI_CONF_SMP_END = 0x01
+    // The firmware's power on configuration.
+    SPI_CONF_DEFAULT = SPI_CONF_CKE_ACTIVE_IDLE
+)
+
+type SPI struct {
+    Bp *BP
+}
+
+func NewSPI(bp *BP) *SPI {
+    return &SPI{Bp: bp}
+} //NewSPI()
+
+// acquire is BP.acquire for a SPI handle.
+func (spi *SPI) acquire() (*SPI, func()) {
+    tx, unlock := spi.Bp.acquire()
+    return &SPI{Bp: tx}, unlock
+} //acquire()
+
+// Transaction runs f with a handle that holds the BP, so nothing from other
+// goroutines gets in between its commands.
+func (spi *SPI) Transaction(f func(tx *SPI) error) error {
+
+    tx, unlock := spi.acquire()
+    defer unlock()
+
+    return f(tx)
+} //Transaction()
+
+// ConfigurePeripherals sets all of the SPI mode peripherals at once.
+func (spi *SPI) ConfigurePeripherals(p Peripherals) error {
+    return spi.Bp.configurePeripherals(MODE_SPI, p.bits())
+} //ConfigurePeripherals()
+
+// Peripherals returns the SPI mode peripherals as last set.
+func (spi *SPI) Peripherals() Peripherals {
+    return spi.Bp.peripherals(MODE_SPI)
+} //Peripherals()
+
+func (spi *SPI) setPeriph(bit uint8, on bool) error {
+    return spi.Bp.setPeriph(MODE_SPI, bit, on)
+} //setPeriph()
+
+func (spi *SPI) Power(on bool) error {
+    return spi.setPeriph(PERIPH_POWER, on)
+} //Power()
+
+func (spi *SPI) Pullups(on bool) error {
+    return spi.setPeriph(PERIPH_PULLUPS, on)
+} //Pullups()
+
+func (spi *SPI) AUX(on bool) error {
+    return spi.setPeriph(PERIPH_AUX, on)
+} //AUX()
+
+// CS sets the CS pin through the peripheral configuration, CSLow and CSHigh
+// are the usual way to frame a transfer.
+func (spi *SPI) CS(on bool) error {
+    return spi.setPeriph(PERIPH_CS, on)
+} //CS()
+
+func (spi *SPI) CSLow() error {
+    err := spi.Bp.modeWriteOK(MODE_SPI, []uint8{SPI_CS_LOW})
+    return err
+} //CSLow()
+
+func (spi *SPI) CSHigh() error {
+    err := spi.Bp.modeWriteOK(MODE_SPI, []uint8{SPI_CS_HIGH})
+    return err
+} //CSHigh()
+
+// SetSpeed sets the SPI clock, one of the SPI_SPEED_* values.
+func (spi *SPI) SetSpeed(speed uint8) error {
+
+    spi, unlock := spi.acquire()
+    defer unlock()
+
+    if speed > SPI_SPEED_8M {
+        return errors.New(fmt.Sprintf("Invalid SPI speed: %d", speed))
+    }
+
+    err := spi.Bp.modeWriteOK(MODE_SPI, []uint8{SPI_SET_SPEED | speed})
+    if err != nil {
+        return err
+    }
+
+    spi.Bp.speed[MODE_SPI] = speed
+    return nil
+} //SetSpeed()
+
+// Speed returns the last speed set with SetSpeed.
+func (spi *SPI) Speed() uint8 {
+    spi, unlock := spi.acquire()
+    defer unlock()
+
+    return spi.Bp.speed[MODE_SPI]
+} //Speed()
+
+// Configure sets the SPI config bits, an OR of the SPI_CONF_* values.
+func (spi *SPI) Configure(config uint8) error {
+
+    spi, unlock := spi.acquire()
+    defer unlock()
+
+    if config > 0x0F {
+        return errors.New(fmt.Sprintf("Invalid SPI config: %x", config))
+    }
+
+    err := spi.Bp.modeWriteOK(MODE_SPI, []uint8{SPI_SET_CONFIG | config})
+    if err != nil {
+        return err
+    }
+
+    spi.Bp.config[MODE_SPI] = config
+    return nil
+} //Configure()
+
+// Config returns the last config set with Configure.
+func (spi *SPI) Config() uint8 {
+    spi, unlock := spi.acquire()
+    defer unlock()
+
+    return spi.Bp.config[MODE_SPI]
+} //Config()
+
+// Transfer clocks out 1-16 bytes and returns the bytes read back. CS is not
+// touched, frame the transfer with CSLow and CSHigh.
+func (spi *SPI) Transfer(data []uint8) ([]uint8, error) {
+
+    if len(data) < 1 || len(data) > SPI_BULK_MAX {
+        return nil, errors.New(fmt.Sprintf(
+            "Can only transfer 1 to %d bytes at a time", SPI_BULK_MAX))
+    }
+
+    sending := []uint8{SPI_BULK_TRANSFER | uint8(len(data)-1)}
+    sending = append(sending, data...)
+
+    res, err := spi.Bp.modeWriteRead(MODE_SPI, sending, len(sending))
+    if err != nil {
+        return res, err
+    }
+
+    if res[0] != 0x01 {
+        return res, &ErrUnexpectedReply{Want: []uint8{0x01}, Got: res[:1]}
+    }
+
+    return res[1:], nil
+} //Transfer()
+
+// WriteRead writes w and then reads n bytes in one command, up to 4096 bytes
+// each way. With cs set the Bus Pirate holds CS low for the whole transfer.
+func (spi *SPI) WriteRead(w []uint8, n int, cs bool) ([]uint8, error) {
+
+    if len(w) > SPI_WRITE_READ_MAX || n < 0 || n > SPI_WRITE_READ_MAX {
+        return nil, errors.New(fmt.Sprintf(
+            "Can't write or read more than %d bytes at a time", SPI_WRITE_READ_MAX))
+    }
+
+    cmd := uint8(SPI_WRITE_READ)
+    if !cs {
+        cmd = SPI_WRITE_READ_NO_CS
+    }
+
+    sending := []uint8{cmd,
+        uint8(len(w) >> 8), uint8(len(w)),
+        uint8(n >> 8), uint8(n)}
+    sending = append(sending, w...)
+
+    spi, unlock := spi.acquire()
+    defer unlock()
+
+    bp := spi.Bp
+    if err := bp.checkMode(MODE_SPI); err != nil {
+        return nil, err
+    }
+
+    // Give the bus time to clock it all through, and the reply time to
+    // arrive.
+    timeout := bp.ReadTimeout + time.Duration(len(w) + n) * SPI_BYTE_TIMEOUT * time.Microsecond +
+        time.Duration(n + 1) * SERIAL_BYTE_TIMEOUT * time.Microsecond
+    ctx, cancel := context.WithTimeout(context.Background(), timeout)
+    defer cancel()
+
+    res, err := bp.writeReadCtx(ctx, sending, n + 1)
+    if err != nil {
+        return res, err
+    }
+
+    if res[0] != 0x01 {
+        return res, &ErrUnexpectedReply{Want: []uint8{0x01}, Got: res[:1]}
+    }
+
+    return res[1:], nil
+} //WriteRead()
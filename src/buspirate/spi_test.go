@@ -0,0 +1,121 @@
+package buspirate
+
+import (
+    "testing"
+)
+
+// simFlash answers the JEDEC ID (0x9F) and READ (0x03, 24-bit address)
+// commands of a SPI NOR flash.
+type simFlash struct {
+    mem []uint8
+    selected bool
+    cmd []uint8
+    addr int
+}
+
+func (f *simFlash) Select(active bool) {
+    f.selected = active
+    f.cmd = nil
+} //Select()
+
+func (f *simFlash) Transfer(mosi uint8) uint8 {
+
+    if !f.selected {
+        return 0xFF
+    }
+
+    f.cmd = append(f.cmd, mosi)
+    switch {
+        case f.cmd[0] == 0x9F && len(f.cmd) > 1 && len(f.cmd) <= 4:
+            return []uint8{0xEF, 0x40, 0x18}[len(f.cmd) - 2]
+        case f.cmd[0] == 0x03 && len(f.cmd) == 4:
+            f.addr = int(f.cmd[1]) << 16 | int(f.cmd[2]) << 8 | int(f.cmd[3])
+        case f.cmd[0] == 0x03 && len(f.cmd) > 4:
+            b := f.mem[f.addr % len(f.mem)]
+            f.addr++
+            return b
+    }
+
+    return 0xFF
+} //Transfer()
+
+func TestSPITransfer (t *testing.T) {
+
+    nbp, em := newEmulatedBP(t)
+    spi, err := nbp.ModeSPI()
+    if err != nil {
+        t.Fatalf("ModeSPI failed: %s", err)
+    }
+    if em.Mode() != EMU_SPI {
+        t.Fatalf("Expected SPI mode, emulator in mode %d", em.Mode())
+    }
+    em.AttachSPI(&simFlash{mem: make([]uint8, 16)})
+
+    if err := spi.CSLow(); err != nil {
+        t.Fatalf("CSLow failed: %s", err)
+    }
+    res, err := spi.Transfer([]uint8{0x9F, 0x00, 0x00, 0x00})
+    if err != nil {
+        t.Fatalf("Transfer failed: %s", err)
+    }
+    if string(res) != "\xFF\xEF\x40\x18" {
+        t.Fatalf("Unexpected JEDEC ID: %q", res)
+    }
+    if err := spi.CSHigh(); err != nil {
+        t.Fatalf("CSHigh failed: %s", err)
+    }
+
+    if _, err := spi.Transfer(make([]uint8, 17)); err == nil {
+        t.Fatalf("Expected an error transferring 17 bytes")
+    }
+} //TestSPITransfer()
+
+func TestSPIWriteRead (t *testing.T) {
+
+    mem := make([]uint8, 1024)
+    for i := range mem {
+        mem[i] = uint8(i)
+    }
+    nbp, em := newEmulatedBP(t)
+    spi, err := nbp.ModeSPI()
+    if err != nil {
+        t.Fatalf("ModeSPI failed: %s", err)
+    }
+    em.AttachSPI(&simFlash{mem: mem})
+
+    res, err := spi.WriteRead([]uint8{0x03, 0x00, 0x01, 0x00}, 300, true)
+    if err != nil {
+        t.Fatalf("WriteRead failed: %s", err)
+    }
+    if len(res) != 300 || res[0] != 0x00 || res[299] != 0x2B {
+        t.Fatalf("Unexpected read back, %d bytes: %X", len(res), res)
+    }
+} //TestSPIWriteRead()
+
+func TestSPISettings (t *testing.T) {
+
+    nbp, _ := newEmulatedBP(t)
+    spi, err := nbp.ModeSPI()
+    if err != nil {
+        t.Fatalf("ModeSPI failed: %s", err)
+    }
+
+    if err := spi.SetSpeed(SPI_SPEED_1M); err != nil || spi.Speed() != SPI_SPEED_1M {
+        t.Fatalf("SetSpeed failed: %s", err)
+    }
+    if err := spi.SetSpeed(0x08); err == nil {
+        t.Fatalf("Expected an error for an invalid speed")
+    }
+
+    conf := uint8(SPI_CONF_OUT_3V3 | SPI_CONF_CKE_ACTIVE_IDLE)
+    if err := spi.Configure(conf); err != nil || spi.Config() != conf {
+        t.Fatalf("Configure failed: %s", err)
+    }
+
+    if err := spi.Power(true); err != nil {
+        t.Fatalf("Power failed: %s", err)
+    }
+    if err := spi.Pullups(true); err != nil {
+        t.Fatalf("Pullups failed: %s", err)
+    }
+} //TestSPISettings()
@@ -0,0 +1,138 @@
+package buspirate
+
+import (
+    "github.com/tarm/goserial"
+    "bytes"
+    "io"
+    "net"
+    "sync"
+    "time"
+)
+
+const (
+    TCP_DIAL_TIMEOUT = 5 * time.Second
+)
+
+// A Transport is the byte stream a BP talks to the Bus Pirate over. All
+// reads and writes made by a BP go through its Transport, so anything that
+// can carry the Bus Pirate's serial protocol can be plugged in: a tty, a
+// ser2net TCP socket, or an in-memory pipe to an Emulator.
+type Transport interface {
+    io.ReadWriteCloser
+}
+
+// OpenSerial opens a serial port Transport on dev at the given baud rate.
+func OpenSerial(dev string, baud int) (Transport, error) {
+    return serial.OpenPort(&serial.Config{Name: dev, Baud: baud})
+} //OpenSerial()
+
+// DialTCP connects to a raw TCP serial bridge, such as ser2net, at addr
+// ("host:port").
+func DialTCP(addr string) (Transport, error) {
+    return net.DialTimeout("tcp", addr, TCP_DIAL_TIMEOUT)
+} //DialTCP()
+
+// Serial returns the connection to the Bus Pirate, nil before Init. It stands
+// in for the Serial field BP had before Transports, which is now a method:
+// bp.Serial becomes bp.Serial().
+//
+// Deprecated: use bp.Transport.
+func (bp *BP) Serial() io.ReadWriteCloser {
+    if bp.Transport == nil {
+        return nil
+    }
+    return bp.Transport
+} //Serial()
+
+// SerialConf returns the serial config Init opens Device with, nil for a BP
+// made with NewBPWithTransport. It stands in for the SerialConf field BP had
+// before Transports.
+//
+// Deprecated: open the port yourself and use NewBPWithTransport.
+func (bp *BP) SerialConf() *serial.Config {
+    if bp.Device == "" {
+        return nil
+    }
+    return &serial.Config{Name: bp.Device, Baud: BAUD}
+} //SerialConf()
+
+// pipeBuffer is one direction of a PipeTransport. Writes never block, reads
+// block until data is available or the buffer is closed.
+type pipeBuffer struct {
+    mu sync.Mutex
+    cond *sync.Cond
+    buf bytes.Buffer
+    closed bool
+}
+
+func newPipeBuffer() *pipeBuffer {
+    pb := &pipeBuffer{}
+    pb.cond = sync.NewCond(&pb.mu)
+    return pb
+} //newPipeBuffer()
+
+func (pb *pipeBuffer) read(p []uint8) (int, error) {
+    pb.mu.Lock()
+    defer pb.mu.Unlock()
+
+    for pb.buf.Len() == 0 && !pb.closed {
+        pb.cond.Wait()
+    }
+
+    if pb.buf.Len() == 0 {
+        return 0, io.EOF
+    }
+
+    return pb.buf.Read(p)
+} //read()
+
+func (pb *pipeBuffer) write(p []uint8) (int, error) {
+    pb.mu.Lock()
+    defer pb.mu.Unlock()
+
+    if pb.closed {
+        return 0, io.ErrClosedPipe
+    }
+
+    n, _ := pb.buf.Write(p)
+    pb.cond.Broadcast()
+    return n, nil
+} //write()
+
+func (pb *pipeBuffer) close() {
+    pb.mu.Lock()
+    pb.closed = true
+    pb.cond.Broadcast()
+    pb.mu.Unlock()
+} //close()
+
+// PipeTransport is one end of an in-memory, buffered, full duplex pipe.
+// Bytes written to one end are read from the other.
+type PipeTransport struct {
+    rd *pipeBuffer
+    wr *pipeBuffer
+}
+
+// NewPipe returns both ends of a new in-memory pipe.
+func NewPipe() (*PipeTransport, *PipeTransport) {
+    a := newPipeBuffer()
+    b := newPipeBuffer()
+
+    return &PipeTransport{rd: a, wr: b}, &PipeTransport{rd: b, wr: a}
+} //NewPipe()
+
+func (pt *PipeTransport) Read(p []uint8) (int, error) {
+    return pt.rd.read(p)
+} //Read()
+
+func (pt *PipeTransport) Write(p []uint8) (int, error) {
+    return pt.wr.write(p)
+} //Write()
+
+// Close closes both directions of the pipe; the other end reads io.EOF once
+// it has drained any buffered bytes.
+func (pt *PipeTransport) Close() error {
+    pt.rd.close()
+    pt.wr.close()
+    return nil
+} //Close()
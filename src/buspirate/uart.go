@@ -0,0 +1,323 @@
+package buspirate
+
+import (
+    "bytes"
+    "log"
+    "errors"
+    "fmt"
+)
+
+const (
+    // http://dangerousprototypes.com/2009/10/19/bus-pirate-binary-uart-mode/
+    // 00000000 – Exit to bitbang mode, responds “BBIOx”
+    // 00000001 – Mode version string (ART1)
+    // 0000001x – Start (0)/stop(1) echo UART RX
+    // 00000111 – Manual baud rate configuration, send 2 bytes
+    // 00001111 – UART bridge mode (reset to exit)
+    // 0001xxxx – Bulk UART write, send 1-16 bytes (0=1byte!)
+    // 0100wxyz – Configure peripherals w=power, x=pullups, y=AUX, z=CS
+    // 0110xxxx – Set UART speed
+    // 100wxxyz – Configure UART settings
+
+    UART_START_ECHO = 0x02
+    UART_STOP_ECHO = 0x03
+    UART_SET_BRG = 0x07
+    UART_BRIDGE = 0x0F
+    UART_BULK_WRITE = 0x10
+    UART_BULK_MAX = 16
+    UART_SET_BAUD = 0x60
+    UART_BAUD_300 = 0x00
+    UART_BAUD_1200 = 0x01
+    UART_BAUD_2400 = 0x02
+    UART_BAUD_4800 = 0x03
+    UART_BAUD_9600 = 0x04
+    UART_BAUD_19200 = 0x05
+    UART_BAUD_31250 = 0x06
+    UART_BAUD_38400 = 0x07
+    UART_BAUD_57600 = 0x08
+    UART_BAUD_115200 = 0x0A
+    UART_SET_CONFIG = 0x80
+    // Pin output, 0=HiZ, 1=3.3v
+    UART_CONF_OUT_3V3 = 0x10
+    // Data bits and parity
+    UART_CONF_8N = 0x00
+    UART_CONF_8E = 0x04
+    UART_CONF_8O = 0x08
+    UART_CONF_9N = 0x0C
+    // Stop bits, 0=1, 1=2
+    UART_CONF_STOP2 = 0x02
+    // RX idle polarity, 0=idle high, 1=idle low
+    UART_CONF_IDLE_LOW = 0x01
+
+    // The PIC's instruction clock, the BRG runs in high speed (BRGH=1) mode.
+    UART_FCY = 16000000
+)
+
+// The baud rates of the UART_BAUD_* presets.
+var uartBauds = map[uint8]int{
+    UART_BAUD_300: 300,
+    UART_BAUD_1200: 1200,
+    UART_BAUD_2400: 2400,
+    UART_BAUD_4800: 4800,
+    UART_BAUD_9600: 9600,
+    UART_BAUD_19200: 19200,
+    UART_BAUD_31250: 31250,
+    UART_BAUD_38400: 38400,
+    UART_BAUD_57600: 57600,
+    UART_BAUD_115200: 115200,
+}
+
+// UART is the Bus Pirate's binary UART mode. While echo is on, received
+// bytes are read with Read and nothing else but Echo(false) is allowed; UART
+// also implements io.Writer for sending.
+type UART struct {
+    Bp *BP
+}
+
+func NewUART(bp *BP) *UART {
+    return &UART{Bp: bp}
+} //NewUART()
+
+// acquire is BP.acquire for a UART handle.
+func (u *UART) acquire() (*UART, func()) {
+    tx, unlock := u.Bp.acquire()
+    return &UART{Bp: tx}, unlock
+} //acquire()
+
+// Transaction runs f with a handle that holds the BP, so nothing from other
+// goroutines gets in between its commands.
+func (u *UART) Transaction(f func(tx *UART) error) error {
+
+    tx, unlock := u.acquire()
+    defer unlock()
+
+    return f(tx)
+} //Transaction()
+
+// ConfigurePeripherals sets all of the UART mode peripherals at once.
+func (u *UART) ConfigurePeripherals(p Peripherals) error {
+    return u.Bp.configurePeripherals(MODE_UART, p.bits())
+} //ConfigurePeripherals()
+
+// Peripherals returns the UART mode peripherals as last set.
+func (u *UART) Peripherals() Peripherals {
+    return u.Bp.peripherals(MODE_UART)
+} //Peripherals()
+
+func (u *UART) setPeriph(bit uint8, on bool) error {
+    return u.Bp.setPeriph(MODE_UART, bit, on)
+} //setPeriph()
+
+func (u *UART) Power(on bool) error {
+    return u.setPeriph(PERIPH_POWER, on)
+} //Power()
+
+func (u *UART) Pullups(on bool) error {
+    return u.setPeriph(PERIPH_PULLUPS, on)
+} //Pullups()
+
+func (u *UART) AUX(on bool) error {
+    return u.setPeriph(PERIPH_AUX, on)
+} //AUX()
+
+func (u *UART) CS(on bool) error {
+    return u.setPeriph(PERIPH_CS, on)
+} //CS()
+
+// SetBaud sets one of the UART_BAUD_* preset rates.
+func (u *UART) SetBaud(preset uint8) error {
+
+    u, unlock := u.acquire()
+    defer unlock()
+
+    baud, ok := uartBauds[preset]
+    if !ok {
+        return errors.New(fmt.Sprintf("Invalid UART baud preset: %x", preset))
+    }
+
+    err := u.Bp.modeWriteOK(MODE_UART, []uint8{UART_SET_BAUD | preset})
+    if err != nil {
+        return err
+    }
+
+    u.Bp.uart_baud = baud
+    return nil
+} //SetBaud()
+
+// SetBRG loads the baud rate generator with a custom value, the resulting
+// rate is UART_FCY / (4 * (brg + 1)).
+func (u *UART) SetBRG(brg uint16) error {
+
+    u, unlock := u.acquire()
+    defer unlock()
+
+    err := u.Bp.modeWriteOK(MODE_UART, []uint8{UART_SET_BRG, uint8(brg >> 8), uint8(brg)})
+    if err != nil {
+        return err
+    }
+
+    u.Bp.uart_baud = UART_FCY / (4 * (int(brg) + 1))
+    return nil
+} //SetBRG()
+
+// SetBaudRate sets any baud rate through the BRG and returns the nearest rate
+// the hardware can actually generate.
+func (u *UART) SetBaudRate(baud int) (int, error) {
+
+    u, unlock := u.acquire()
+    defer unlock()
+
+    if baud <= 0 || baud > UART_FCY / 4 {
+        return 0, errors.New(fmt.Sprintf("Invalid UART baud rate: %d", baud))
+    }
+
+    brg := (UART_FCY + 2 * baud) / (4 * baud) - 1
+    if brg > 0xFFFF {
+        return 0, errors.New(fmt.Sprintf("UART baud rate too low: %d", baud))
+    }
+
+    err := u.SetBRG(uint16(brg))
+    return u.Bp.uart_baud, err
+} //SetBaudRate()
+
+// Baud returns the last baud rate set.
+func (u *UART) Baud() int {
+    u, unlock := u.acquire()
+    defer unlock()
+
+    return u.Bp.uart_baud
+} //Baud()
+
+// Configure sets the UART config bits, an OR of the UART_CONF_* values.
+func (u *UART) Configure(config uint8) error {
+
+    u, unlock := u.acquire()
+    defer unlock()
+
+    if config > 0x1F {
+        return errors.New(fmt.Sprintf("Invalid UART config: %x", config))
+    }
+
+    err := u.Bp.modeWriteOK(MODE_UART, []uint8{UART_SET_CONFIG | config})
+    if err != nil {
+        return err
+    }
+
+    u.Bp.config[MODE_UART] = config
+    return nil
+} //Configure()
+
+// Config returns the last config set with Configure.
+func (u *UART) Config() uint8 {
+    u, unlock := u.acquire()
+    defer unlock()
+
+    return u.Bp.config[MODE_UART]
+} //Config()
+
+// Echo turns forwarding of received bytes on or off. While echo is on, the
+// Bus Pirate's command replies would share the stream with the received
+// data, so every other UART command returns ErrUARTEcho until Echo(false).
+func (u *UART) Echo(on bool) error {
+
+    u, unlock := u.acquire()
+    defer unlock()
+
+    err := u.Bp.checkMode(MODE_UART)
+    if err != nil && !(err == ErrUARTEcho && !on) {
+        return err
+    }
+
+    cmd := uint8(UART_STOP_ECHO)
+    if on {
+        cmd = UART_START_ECHO
+    }
+
+    res, err := u.Bp.writeReadN([]uint8{cmd}, 1)
+    if err != nil {
+        return err
+    }
+    if res[0] != 0x01 {
+        return &ErrUnexpectedReply{Want: []uint8{0x01}, Got: res}
+    }
+
+    u.Bp.uart_echo = on
+    return nil
+} //Echo()
+
+// Read reads received bytes, blocking until at least one is available. Only
+// useful while echo is on. Read doesn't hold the BP while it waits, so
+// Echo(false) from another goroutine can take received bytes as its reply.
+func (u *UART) Read(p []uint8) (int, error) {
+    return u.Bp.readStream(p)
+} //Read()
+
+// Write sends p to the UART in bulk writes of up to 16 bytes. Returns
+// ErrUARTEcho while echo is on.
+func (u *UART) Write(p []uint8) (int, error) {
+
+    u, unlock := u.acquire()
+    defer unlock()
+
+    sent := 0
+    for sent < len(p) {
+        chunk := p[sent:]
+        if len(chunk) > UART_BULK_MAX {
+            chunk = chunk[:UART_BULK_MAX]
+        }
+
+        sending := []uint8{UART_BULK_WRITE | uint8(len(chunk)-1)}
+        sending = append(sending, chunk...)
+
+        // 0x01 for the command and for every byte written.
+        res, err := u.Bp.modeWriteRead(MODE_UART, sending, len(sending))
+        if err != nil {
+            return sent, err
+        }
+        for _, r := range res {
+            if r != 0x01 {
+                return sent, &ErrUnexpectedReply{Want: bytes.Repeat([]uint8{0x01}, len(sending)), Got: res}
+            }
+        }
+
+        sent += len(chunk)
+    }
+
+    return sent, nil
+} //Write()
+
+// Bridge puts the Bus Pirate in transparent UART bridge mode and returns the
+// raw byte stream to the target. There is no way back out of bridge mode
+// other than resetting the Bus Pirate by hand.
+func (u *UART) Bridge() (*UARTBridge, error) {
+
+    u, unlock := u.acquire()
+    defer unlock()
+
+    if err := u.Bp.checkMode(MODE_UART); err != nil {
+        return nil, err
+    }
+
+    _, err := u.Bp.write([]uint8{UART_BRIDGE})
+    if err != nil {
+        return nil, err
+    }
+
+    // We no longer know what state the Bus Pirate is in.
+    u.Bp.state = 0
+    log.Printf("Entered UART bridge mode.")
+    return &UARTBridge{Bp: u.Bp}, nil
+} //Bridge()
+
+// UARTBridge is the transparent stream to the target after UART.Bridge.
+type UARTBridge struct {
+    Bp *BP
+}
+
+func (br *UARTBridge) Read(p []uint8) (int, error) {
+    return br.Bp.readStream(p)
+} //Read()
+
+func (br *UARTBridge) Write(p []uint8) (int, error) {
+    return br.Bp.write(p)
+} //Write()
@@ -0,0 +1,122 @@
+package buspirate
+
+import (
+    "io"
+    "testing"
+    "time"
+)
+
+func TestUARTSettings (t *testing.T) {
+
+    nbp, em := newEmulatedBP(t)
+    u, err := nbp.ModeUART()
+    if err != nil {
+        t.Fatalf("ModeUART failed: %s", err)
+    }
+    if em.Mode() != EMU_UART {
+        t.Fatalf("Expected UART mode, emulator in mode %d", em.Mode())
+    }
+
+    if err := u.SetBaud(UART_BAUD_115200); err != nil || u.Baud() != 115200 {
+        t.Fatalf("SetBaud failed: %d, %s", u.Baud(), err)
+    }
+    if err := u.SetBaud(0x09); err == nil {
+        t.Fatalf("Expected an error for an invalid baud preset")
+    }
+
+    baud, err := u.SetBaudRate(250000)
+    if err != nil || baud != 250000 {
+        t.Fatalf("SetBaudRate failed: %d, %s", baud, err)
+    }
+
+    conf := uint8(UART_CONF_OUT_3V3 | UART_CONF_8E | UART_CONF_STOP2)
+    if err := u.Configure(conf); err != nil || u.Config() != conf {
+        t.Fatalf("Configure failed: %s", err)
+    }
+} //TestUARTSettings()
+
+func TestUARTWriteEcho (t *testing.T) {
+
+    nbp, em := newEmulatedBP(t)
+    u, err := nbp.ModeUART()
+    if err != nil {
+        t.Fatalf("ModeUART failed: %s", err)
+    }
+
+    msg := []uint8("The quick brown fox jumps over the lazy dog")
+    n, err := u.Write(msg)
+    if err != nil || n != len(msg) {
+        t.Fatalf("Write failed: %d, %s", n, err)
+    }
+    if string(em.UARTReceived()) != string(msg) {
+        t.Fatalf("Target did not receive %q", msg)
+    }
+
+    if err := u.Echo(true); err != nil {
+        t.Fatalf("Echo failed: %s", err)
+    }
+    em.UARTSend([]uint8("login: "))
+
+    buf := make([]uint8, 7)
+    if _, err := io.ReadFull(u, buf); err != nil || string(buf) != "login: " {
+        t.Fatalf("Expected %q, got %q, %s", "login: ", buf, err)
+    }
+
+    // Nothing but Echo(false) while echo is on.
+    if _, err := u.Write([]uint8("root\r")); err != ErrUARTEcho {
+        t.Fatalf("Expected ErrUARTEcho from Write, got %v", err)
+    }
+    if err := u.SetBaud(UART_BAUD_9600); err != ErrUARTEcho {
+        t.Fatalf("Expected ErrUARTEcho from SetBaud, got %v", err)
+    }
+    if len(em.UARTReceived()) != 0 {
+        t.Fatalf("Target received bytes while echo was on")
+    }
+
+    if err := u.Echo(false); err != nil {
+        t.Fatalf("Echo off failed: %s", err)
+    }
+    if _, err := u.Write([]uint8("root\r")); err != nil {
+        t.Fatalf("Write after echo off failed: %s", err)
+    }
+    if string(em.UARTReceived()) != "root\r" {
+        t.Fatalf("Target did not receive %q", "root\r")
+    }
+} //TestUARTWriteEcho()
+
+func TestUARTBridge (t *testing.T) {
+
+    nbp, em := newEmulatedBP(t)
+    u, err := nbp.ModeUART()
+    if err != nil {
+        t.Fatalf("ModeUART failed: %s", err)
+    }
+
+    br, err := u.Bridge()
+    if err != nil {
+        t.Fatalf("Bridge failed: %s", err)
+    }
+
+    // Bridge mode has no reply, wait for the emulator to get there.
+    for em.Mode() != EMU_UART_BRIDGE {
+        time.Sleep(time.Millisecond)
+    }
+
+    br.Write([]uint8("ls\r"))
+    em.UARTSend([]uint8("ok\r\n"))
+
+    buf := make([]uint8, 4)
+    if _, err := io.ReadFull(br, buf); err != nil || string(buf) != "ok\r\n" {
+        t.Fatalf("Expected %q, got %q, %s", "ok\r\n", buf, err)
+    }
+
+    var rx []uint8
+    deadline := time.Now().Add(time.Second)
+    for len(rx) < 3 && time.Now().Before(deadline) {
+        rx = append(rx, em.UARTReceived()...)
+        time.Sleep(time.Millisecond)
+    }
+    if string(rx) != "ls\r" {
+        t.Fatalf("Target did not receive the bridged bytes: %q", rx)
+    }
+} //TestUARTBridge()
@@ -28,6 +28,7 @@ func main() {
     if err != nil {
         log.Fatal(err)
     }
+    defer bp.Close()
 
     //bp.Reset()
     bp.BinaryMode()
@@ -47,18 +48,16 @@ func main() {
     i2c.Power(true)
     i2c.Pullups(true)
     // fmt.Printf("Starting scan...\n")
-    // scan_res := i2c.Scan()
+    // scan_res, err := i2c.Scan(context.Background(), buspirate.ScanOptions{Identify: true})
     // for _, v := range scan_res  {
-    //     if (v & 0x01) == 0x01 {
-    //         fmt.Printf("0x%2.2X(0x%2.2X R)\n",  v >> 1, v)
-    //     } else {
-    //         fmt.Printf("0x%2.2X(0x%2.2X W)\n", v >> 1, v)
-    //     }
+    //     fmt.Printf("0x%2.2X R:%t W:%t %s\n", v.Addr7, v.ReadAck, v.WriteAck, v.Device)
     // }
 
     // time.Sleep(300 * time.Millisecond)
 
-    pwm.AllOn(i2c, 0, 4095)
+    if err := pwm.AllOn(i2c, 0, 4095); err != nil {
+        log.Printf("AllOn failed: %s\n", err)
+    }
     i2c.Power(false)
     i2c.Pullups(false)
 
@@ -0,0 +1,353 @@
+package pwm
+
+import (
+    "buspirate"
+    "errors"
+    "fmt"
+    "math"
+    "time"
+)
+
+const (
+    // https://www.nxp.com/docs/en/data-sheet/PCA9685.pdf
+    PCA9685_ADDR = 0x40
+    PCA9685_MODE1 = 0x00
+    PCA9685_MODE2 = 0x01
+    PCA9685_LED0 = 0x06
+    PCA9685_ALL_LED = 0xFA
+    PCA9685_PRE_SCALE = 0xFE
+
+    MODE1_RESTART = 0x80
+    MODE1_AI = 0x20
+    MODE1_SLEEP = 0x10
+    MODE1_ALLCALL = 0x01
+    // Totem pole outputs, rather than open drain
+    MODE2_OUTDRV = 0x04
+
+    // Bit 12 of the on and off counts, full on or full off
+    PCA9685_FULL = 0x1000
+    PCA9685_MAX_COUNT = 4095
+    PCA9685_CHANNELS = 16
+    // Counts in a PWM period
+    PCA9685_STEPS = 4096
+
+    // The internal oscillator, Hz
+    PCA9685_OSC = 25000000
+    PCA9685_PRE_SCALE_MIN = 3
+    PCA9685_PRE_SCALE_MAX = 255
+    // How long the oscillator takes to start after leaving sleep
+    PCA9685_WAKE_TIME = 500 * time.Microsecond
+
+    // Standard hobby servo pulses, 1ms to 2ms over 180 degrees, at 50Hz
+    SERVO_MIN_PULSE = 1000 * time.Microsecond
+    SERVO_MAX_PULSE = 2000 * time.Microsecond
+    SERVO_RANGE = 180
+    SERVO_FREQ = 50
+)
+
+// PCA9685 is an NXP PCA9685 16 channel, 12-bit PWM controller on a Bus
+// Pirate's I2C bus. Osc is the oscillator frequency, PCA9685_OSC unless
+// there's an external clock.
+type PCA9685 struct {
+    I2C *buspirate.I2C
+    Addr uint8
+    Osc int
+
+    freq float64
+}
+
+func NewPCA9685(i2c *buspirate.I2C, addr uint8) *PCA9685 {
+    return &PCA9685{I2C: i2c, Addr: addr, Osc: PCA9685_OSC}
+} //NewPCA9685()
+
+// Init wakes the chip with register auto-increment on, which the other
+// methods rely on, and sets totem pole outputs.
+func (pca *PCA9685) Init() error {
+
+    return pca.I2C.Transaction(func(tx *buspirate.I2C) error {
+        err := tx.WriteReg8(pca.Addr, PCA9685_MODE2, MODE2_OUTDRV)
+        if err != nil {
+            return err
+        }
+
+        err = tx.WriteReg8(pca.Addr, PCA9685_MODE1, MODE1_AI | MODE1_ALLCALL)
+        if err != nil {
+            return err
+        }
+
+        time.Sleep(PCA9685_WAKE_TIME)
+        return nil
+    })
+} //Init()
+
+// SetFreq sets the PWM frequency of all channels, which can only be done
+// while asleep, and returns the frequency the prescaler actually gives.
+func (pca *PCA9685) SetFreq(hz float64) (float64, error) {
+
+    if hz <= 0 {
+        return 0, errors.New(fmt.Sprintf("Invalid PWM frequency: %v", hz))
+    }
+
+    prescale := math.Round(float64(pca.Osc) / (PCA9685_STEPS * hz)) - 1
+    if prescale < PCA9685_PRE_SCALE_MIN || prescale > PCA9685_PRE_SCALE_MAX {
+        return 0, errors.New(fmt.Sprintf("PWM frequency out of range: %v", hz))
+    }
+
+    err := pca.I2C.Transaction(func(tx *buspirate.I2C) error {
+        mode1, err := tx.ReadReg8(pca.Addr, PCA9685_MODE1)
+        if err != nil {
+            return err
+        }
+
+        awake := mode1 &^ (MODE1_RESTART | MODE1_SLEEP)
+        err = tx.WriteReg8(pca.Addr, PCA9685_MODE1, awake | MODE1_SLEEP)
+        if err != nil {
+            return err
+        }
+
+        err = tx.WriteReg8(pca.Addr, PCA9685_PRE_SCALE, uint8(prescale))
+        if err != nil {
+            return err
+        }
+
+        // Put back whether it was asleep.
+        if mode1 & MODE1_SLEEP != 0 {
+            return tx.WriteReg8(pca.Addr, PCA9685_MODE1, awake | MODE1_SLEEP)
+        }
+
+        return pca.restart(tx, awake)
+    })
+    if err != nil {
+        return 0, err
+    }
+
+    pca.freq = pca.prescaleFreq(uint8(prescale))
+    return pca.freq, nil
+} //SetFreq()
+
+// Freq returns the PWM frequency, from the prescaler.
+func (pca *PCA9685) Freq() (float64, error) {
+
+    prescale, err := pca.I2C.ReadReg8(pca.Addr, PCA9685_PRE_SCALE)
+    if err != nil {
+        return 0, err
+    }
+
+    pca.freq = pca.prescaleFreq(prescale)
+    return pca.freq, nil
+} //Freq()
+
+func (pca *PCA9685) prescaleFreq(prescale uint8) float64 {
+    return float64(pca.Osc) / (PCA9685_STEPS * (float64(prescale) + 1))
+} //prescaleFreq()
+
+// restart wakes the chip and restarts the PWM channels where they were before
+// it went to sleep.
+func (pca *PCA9685) restart(tx *buspirate.I2C, awake uint8) error {
+
+    err := tx.WriteReg8(pca.Addr, PCA9685_MODE1, awake)
+    if err != nil {
+        return err
+    }
+
+    time.Sleep(PCA9685_WAKE_TIME)
+    return tx.WriteReg8(pca.Addr, PCA9685_MODE1, awake | MODE1_RESTART)
+} //restart()
+
+// Sleep stops the oscillator, turning every output off.
+func (pca *PCA9685) Sleep() error {
+    return pca.I2C.UpdateBits(pca.Addr, PCA9685_MODE1, MODE1_SLEEP | MODE1_RESTART, MODE1_SLEEP)
+} //Sleep()
+
+// Restart wakes the chip after Sleep with the outputs as they were.
+func (pca *PCA9685) Restart() error {
+
+    return pca.I2C.Transaction(func(tx *buspirate.I2C) error {
+        mode1, err := tx.ReadReg8(pca.Addr, PCA9685_MODE1)
+        if err != nil {
+            return err
+        }
+
+        return pca.restart(tx, mode1 &^ (MODE1_RESTART | MODE1_SLEEP))
+    })
+} //Restart()
+
+func checkChannel(ch int) error {
+
+    if ch < 0 || ch >= PCA9685_CHANNELS {
+        return errors.New(fmt.Sprintf("Invalid PCA9685 channel: %d", ch))
+    }
+
+    return nil
+} //checkChannel()
+
+// setCounts writes on and off counts, full bits included, to the four
+// registers starting at reg.
+func (pca *PCA9685) setCounts(reg uint8, on, off uint16) error {
+    return pca.I2C.WriteRegs(pca.Addr, reg,
+        []uint8{uint8(on), uint8(on >> 8), uint8(off), uint8(off >> 8)})
+} //setCounts()
+
+func checkCounts(on, off uint16) error {
+
+    if on > PCA9685_MAX_COUNT || off > PCA9685_MAX_COUNT {
+        return errors.New(fmt.Sprintf("PWM counts must be 0-%d, got %d, %d", PCA9685_MAX_COUNT, on, off))
+    }
+
+    return nil
+} //checkCounts()
+
+// SetPWM sets the counts, out of 4096, at which channel ch turns on and off.
+func (pca *PCA9685) SetPWM(ch int, on, off uint16) error {
+
+    if err := checkChannel(ch); err != nil {
+        return err
+    }
+    if err := checkCounts(on, off); err != nil {
+        return err
+    }
+
+    return pca.setCounts(uint8(PCA9685_LED0 + 4 * ch), on, off)
+} //SetPWM()
+
+// SetAllPWM is SetPWM for every channel at once.
+func (pca *PCA9685) SetAllPWM(on, off uint16) error {
+
+    if err := checkCounts(on, off); err != nil {
+        return err
+    }
+
+    return pca.setCounts(PCA9685_ALL_LED, on, off)
+} //SetAllPWM()
+
+// PWM returns channel ch's on and off counts, with PCA9685_FULL set for full
+// on or off.
+func (pca *PCA9685) PWM(ch int) (uint16, uint16, error) {
+
+    if err := checkChannel(ch); err != nil {
+        return 0, 0, err
+    }
+
+    buf := make([]uint8, 4)
+    err := pca.I2C.ReadRegs(pca.Addr, uint8(PCA9685_LED0 + 4 * ch), buf)
+    if err != nil {
+        return 0, 0, err
+    }
+
+    return uint16(buf[1]) << 8 | uint16(buf[0]), uint16(buf[3]) << 8 | uint16(buf[2]), nil
+} //PWM()
+
+// SetDuty sets channel ch to be on for duty, 0 to 1, of each period, using
+// full on and full off at the ends.
+func (pca *PCA9685) SetDuty(ch int, duty float64) error {
+
+    if duty < 0 || duty > 1 {
+        return errors.New(fmt.Sprintf("Invalid duty cycle: %v", duty))
+    }
+
+    switch duty {
+        case 0:
+            return pca.FullOff(ch)
+        case 1:
+            return pca.FullOn(ch)
+    }
+
+    off := math.Min(math.Round(duty * PCA9685_STEPS), PCA9685_MAX_COUNT)
+    return pca.SetPWM(ch, 0, uint16(off))
+} //SetDuty()
+
+// FullOn turns channel ch on, with no PWM.
+func (pca *PCA9685) FullOn(ch int) error {
+
+    if err := checkChannel(ch); err != nil {
+        return err
+    }
+
+    return pca.setCounts(uint8(PCA9685_LED0 + 4 * ch), PCA9685_FULL, 0)
+} //FullOn()
+
+// FullOff turns channel ch off, which takes priority over full on.
+func (pca *PCA9685) FullOff(ch int) error {
+
+    if err := checkChannel(ch); err != nil {
+        return err
+    }
+
+    return pca.setCounts(uint8(PCA9685_LED0 + 4 * ch), 0, PCA9685_FULL)
+} //FullOff()
+
+// AllFullOn turns every channel on.
+func (pca *PCA9685) AllFullOn() error {
+    return pca.setCounts(PCA9685_ALL_LED, PCA9685_FULL, 0)
+} //AllFullOn()
+
+// AllFullOff turns every channel off.
+func (pca *PCA9685) AllFullOff() error {
+    return pca.setCounts(PCA9685_ALL_LED, 0, PCA9685_FULL)
+} //AllFullOff()
+
+// SetPulse sets channel ch to a pulse of width every period, as servos and
+// ESCs want. Uses the frequency from SetFreq, or reads it.
+func (pca *PCA9685) SetPulse(ch int, width time.Duration) error {
+
+    freq := pca.freq
+    if freq == 0 {
+        var err error
+        freq, err = pca.Freq()
+        if err != nil {
+            return err
+        }
+    }
+
+    counts := math.Round(width.Seconds() * freq * PCA9685_STEPS)
+    if counts < 0 || counts > PCA9685_MAX_COUNT {
+        return errors.New(fmt.Sprintf("Pulse of %s doesn't fit a %vHz period", width, freq))
+    }
+
+    return pca.SetPWM(ch, 0, uint16(counts))
+} //SetPulse()
+
+// Servo is a hobby servo on one PCA9685 channel, MinPulse puts it at 0
+// degrees and MaxPulse at Range degrees.
+type Servo struct {
+    PCA *PCA9685
+    Channel int
+    MinPulse time.Duration
+    MaxPulse time.Duration
+    Range float64
+}
+
+// Servo returns a standard 1ms to 2ms, 180 degree, servo on channel ch. The
+// PCA9685 should be at SERVO_FREQ.
+func (pca *PCA9685) Servo(ch int) *Servo {
+    return &Servo{PCA: pca, Channel: ch,
+        MinPulse: SERVO_MIN_PULSE, MaxPulse: SERVO_MAX_PULSE, Range: SERVO_RANGE}
+} //Servo()
+
+// SetAngle moves the servo to deg degrees, 0 to Range.
+func (s *Servo) SetAngle(deg float64) error {
+
+    if deg < 0 || deg > s.Range {
+        return errors.New(fmt.Sprintf("Servo angle must be 0-%v, got %v", s.Range, deg))
+    }
+
+    width := s.MinPulse + time.Duration(deg / s.Range * float64(s.MaxPulse - s.MinPulse))
+    return s.PCA.SetPulse(s.Channel, width)
+} //SetAngle()
+
+// Off stops sending pulses, so the servo goes limp.
+func (s *Servo) Off() error {
+    return s.PCA.FullOff(s.Channel)
+} //Off()
+
+// AllOn wakes the PCA9685 at the default address and sets every channel to
+// turn on at count on and off at count off.
+func AllOn(i2c *buspirate.I2C, on, off uint16) error {
+
+    pca := NewPCA9685(i2c, PCA9685_ADDR)
+    if err := pca.Init(); err != nil {
+        return err
+    }
+
+    return pca.SetAllPWM(on, off)
+} //AllOn()
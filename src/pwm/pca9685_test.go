@@ -0,0 +1,152 @@
+package pwm
+
+import (
+    "buspirate"
+    "testing"
+    "time"
+)
+
+// newEmulatedPCA9685 returns a PCA9685 driver talking to a simulated chip on
+// an emulated Bus Pirate.
+func newEmulatedPCA9685(t *testing.T) (*PCA9685, *buspirate.SimPCA9685) {
+
+    em := buspirate.NewEmulator()
+    sim := buspirate.NewSimPCA9685(PCA9685_ADDR)
+    em.AttachI2C(sim)
+
+    bp := buspirate.NewBPWithTransport(em.Transport())
+    if err := bp.Init(); err != nil {
+        t.Fatalf("Init failed: %s", err)
+    }
+    t.Cleanup(func() { bp.Close() })
+
+    i2c, err := bp.ModeI2C()
+    if err != nil {
+        t.Fatalf("ModeI2C failed: %s", err)
+    }
+
+    pca := NewPCA9685(i2c, PCA9685_ADDR)
+    if err := pca.Init(); err != nil {
+        t.Fatalf("PCA9685 Init failed: %s", err)
+    }
+
+    return pca, sim
+} //newEmulatedPCA9685()
+
+func TestPCA9685 (t *testing.T) {
+
+    pca, sim := newEmulatedPCA9685(t)
+
+    if sim.Regs[PCA9685_MODE1] != MODE1_AI | MODE1_ALLCALL {
+        t.Fatalf("Expected MODE1 awake with AI, got %X", sim.Regs[PCA9685_MODE1])
+    }
+
+    freq, err := pca.SetFreq(1000)
+    if err != nil {
+        t.Fatalf("SetFreq failed: %s", err)
+    }
+    // 25MHz / (4096 * 1000) rounds to 6, a prescale of 5
+    if sim.Regs[PCA9685_PRE_SCALE] != 5 || freq < 1017 || freq > 1018 {
+        t.Fatalf("Expected prescale 5, ~1017Hz, got %d, %v", sim.Regs[PCA9685_PRE_SCALE], freq)
+    }
+    if sim.Regs[PCA9685_MODE1] & MODE1_SLEEP != 0 {
+        t.Fatalf("Left asleep after SetFreq: %X", sim.Regs[PCA9685_MODE1])
+    }
+    if _, err := pca.SetFreq(5000); err == nil {
+        t.Fatalf("Expected an error for 5kHz")
+    }
+
+    if err := pca.SetPWM(3, 100, 2000); err != nil {
+        t.Fatalf("SetPWM failed: %s", err)
+    }
+    if on, off := sim.Channel(3); on != 100 || off != 2000 {
+        t.Fatalf("Expected 100/2000, got %d/%d", on, off)
+    }
+    if on, off, err := pca.PWM(3); err != nil || on != 100 || off != 2000 {
+        t.Fatalf("PWM expected 100/2000, got %d/%d, %v", on, off, err)
+    }
+    if err := pca.SetPWM(16, 0, 0); err == nil {
+        t.Fatalf("Expected an error for channel 16")
+    }
+
+    if err := pca.SetAllPWM(0, 4095); err != nil {
+        t.Fatalf("SetAllPWM failed: %s", err)
+    }
+    for ch := 0; ch < PCA9685_CHANNELS; ch++ {
+        if on, off := sim.Channel(ch); on != 0 || off != 4095 {
+            t.Fatalf("Expected channel %d at 0/4095, got %d/%d", ch, on, off)
+        }
+    }
+
+    if err := pca.FullOn(0); err != nil {
+        t.Fatalf("FullOn failed: %s", err)
+    }
+    if err := pca.SetDuty(1, 0); err != nil {
+        t.Fatalf("SetDuty failed: %s", err)
+    }
+    if err := pca.SetDuty(2, 0.25); err != nil {
+        t.Fatalf("SetDuty failed: %s", err)
+    }
+    if on, _ := sim.Channel(0); on != PCA9685_FULL {
+        t.Fatalf("Expected channel 0 full on, got %X", on)
+    }
+    if _, off := sim.Channel(1); off != PCA9685_FULL {
+        t.Fatalf("Expected channel 1 full off, got %X", off)
+    }
+    if _, off := sim.Channel(2); off != 1024 {
+        t.Fatalf("Expected channel 2 off at 1024, got %d", off)
+    }
+
+    if err := pca.Sleep(); err != nil || sim.Regs[PCA9685_MODE1] & MODE1_SLEEP == 0 {
+        t.Fatalf("Sleep failed: %v, MODE1 %X", err, sim.Regs[PCA9685_MODE1])
+    }
+    if err := pca.Restart(); err != nil || sim.Regs[PCA9685_MODE1] != MODE1_AI | MODE1_ALLCALL {
+        t.Fatalf("Restart failed: %v, MODE1 %X", err, sim.Regs[PCA9685_MODE1])
+    }
+} //TestPCA9685()
+
+func TestPCA9685Servo (t *testing.T) {
+
+    pca, sim := newEmulatedPCA9685(t)
+
+    if _, err := pca.SetFreq(SERVO_FREQ); err != nil {
+        t.Fatalf("SetFreq failed: %s", err)
+    }
+
+    // A fresh driver reads the frequency back from the chip.
+    pca = NewPCA9685(pca.I2C, PCA9685_ADDR)
+    servo := pca.Servo(5)
+
+    // 1.5ms of a ~20ms period is about 307 counts.
+    if err := servo.SetAngle(90); err != nil {
+        t.Fatalf("SetAngle failed: %s", err)
+    }
+    if _, off := sim.Channel(5); off < 300 || off > 310 {
+        t.Fatalf("Expected ~307 counts for 90 degrees, got %d", off)
+    }
+    if err := servo.SetAngle(181); err == nil {
+        t.Fatalf("Expected an error for 181 degrees")
+    }
+    if err := pca.SetPulse(5, 30 * time.Millisecond); err == nil {
+        t.Fatalf("Expected an error for a pulse longer than the period")
+    }
+
+    if err := servo.Off(); err != nil {
+        t.Fatalf("Off failed: %s", err)
+    }
+    if _, off := sim.Channel(5); off != PCA9685_FULL {
+        t.Fatalf("Expected full off, got %X", off)
+    }
+} //TestPCA9685Servo()
+
+func TestAllOn (t *testing.T) {
+
+    pca, sim := newEmulatedPCA9685(t)
+
+    if err := AllOn(pca.I2C, 0, 4095); err != nil {
+        t.Fatalf("AllOn failed: %s", err)
+    }
+    if on, off := sim.Channel(15); on != 0 || off != 4095 {
+        t.Fatalf("Expected 0/4095, got %d/%d", on, off)
+    }
+} //TestAllOn()